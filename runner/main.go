@@ -70,6 +70,8 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"cirello.io/runner/procfile"
 	"cirello.io/runner/runner"
@@ -81,12 +83,20 @@ const DefaultProcfile = "Procfile"
 
 var (
 	convertToJSON = flag.Bool("convert", false, "takes a declared Procfile and prints as JSON to standard output")
+	printGraph    = flag.Bool("graph", false, "takes a declared Procfile and prints the dependency graph of its process types, in Graphviz DOT format, to standard output")
 	basePort      = flag.Int("port", 5000, "base IP port used to set $`PORT` for each process type. Should be multiple of 1000.")
 	discoveryAddr = flag.String("service-discovery", "localhost:0", "service discovery address")
 	formation     = flag.String("formation", "", "formation allows to start more than one instance of a process type, format: `procTypeA=# procTypeB=# ... procTypeN=#`")
 	envFn         = flag.String("env", ".env", "environment `file` to be loaded for all processes.")
 	skipProcs     = flag.String("skip", "", "does not run some of the process types, format: `procTypeA procTypeB procTypeN`")
 	onlyProcs     = flag.String("only", "", "only runs some of the process types, format: `procTypeA procTypeB procTypeN`")
+	summary       = flag.Bool("summary", false, "prints a report of restarts, exit codes and uptime of each process type on exit")
+	pidFile       = flag.String("pidfile", "", "`file` into which the runner writes its process ID")
+	skipBuilds    = flag.Bool("skip-builds", false, "starts the process types without running the build process types")
+	watchOnly     = flag.Bool("watch-only", false, "prints the file changes detected, without starting any process type")
+	watchConfig   = flag.Bool("watch-config", false, "reloads the process types when the Procfile changes")
+	waitTimeout   = flag.Duration("wait-timeout", 0, "fails the run if any process type is not ready within this `duration` (zero disables it)")
+	gracePeriod   = flag.Duration("grace", 10*time.Second, "how long processes are given to exit after SIGTERM before being killed")
 )
 
 func init() {
@@ -164,11 +174,12 @@ func main() {
 		log.Fatalln("invalid IP port")
 	}
 
-	var s runner.Runner
+	var s *runner.Runner
 
 	switch filepath.Ext(fn) {
 	case ".json":
-		if err := json.NewDecoder(fd).Decode(&s); err != nil {
+		s = new(runner.Runner)
+		if err := s.Load(fn); err != nil {
 			log.Fatalln("cannot parse spec file (json):", err)
 		}
 	default:
@@ -181,12 +192,17 @@ func main() {
 	if *convertToJSON {
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "    ")
-		if err := enc.Encode(&s); err != nil {
+		if err := enc.Encode(s); err != nil {
 			log.Fatalln("cannot encode procfile into JSON:", err)
 		}
 		return
 	}
 
+	if *printGraph {
+		fmt.Print(s.DependencyGraph())
+		return
+	}
+
 	s.WorkDir = os.ExpandEnv(s.WorkDir)
 	if s.WorkDir == "" {
 		wd, err := os.Getwd()
@@ -202,36 +218,91 @@ func main() {
 	defer cancel()
 	go func() {
 		<-c
-		log.Println("shutting down")
+		log.Println("shutting down, press Ctrl-C again to force")
 		cancel()
+		<-c
+		log.Println("forcing shutdown")
+		s.ForceStop()
 	}()
 
 	s.BasePort = *basePort
 
-	if fd, err := os.Open(*envFn); err == nil {
-		scanner := bufio.NewScanner(fd)
-		for scanner.Scan() {
-			line := strings.Split(strings.TrimSpace(scanner.Text()), "=")
-			if len(line) != 2 {
+	configEnv := s.BaseEnvironment
+	if env, err := runner.LoadEnvFile(*envFn); err == nil {
+		s.BaseEnvironment = append(append([]string(nil), configEnv...), env...)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("error reading environment file (%v): %v", *envFn, err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			env, err := runner.LoadEnvFile(*envFn)
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("error reloading environment file (%v): %v", *envFn, err)
 				continue
 			}
+			log.Println("reloaded environment file, it applies to processes as they restart")
+			s.SetBaseEnvironment(append(append([]string(nil), configEnv...), env...))
+		}
+	}()
 
-			s.BaseEnvironment = append(s.BaseEnvironment, scanner.Text())
+	s.Processes = selectProcs(s.Processes)
+	s.Formation = filterFormation(s.Formation, s.Processes)
+	s.ServiceDiscoveryAddr = *discoveryAddr
+	s.Summary = *summary
+	s.ShutdownGracePeriod = *gracePeriod
+	s.WaitTimeout = *waitTimeout
+	s.SkipBuilds = *skipBuilds
+	s.WatchOnly = *watchOnly
+	if *watchConfig {
+		s.ConfigPath = fn
+		s.WatchConfig = true
+		s.LoadConfig = func(fn string) ([]*runner.ProcessType, error) {
+			procs, err := loadProcessTypes(fn)
+			if err != nil {
+				return nil, err
+			}
+			return selectProcs(procs), nil
 		}
-		if err := scanner.Err(); err != nil {
-			log.Fatalf("error reading environment file (%v): %v", *envFn, err)
+	}
+	s.PidFile = *pidFile
+	if err := s.Start(ctx); err != nil {
+		log.Fatalln("cannot serve:", err)
+	}
+}
+
+// loadProcessTypes reads the process types of the Procfile or JSON
+// configuration fn, for their reload.
+func loadProcessTypes(fn string) ([]*runner.ProcessType, error) {
+	if filepath.Ext(fn) == ".json" {
+		var s runner.Runner
+		if err := s.Load(fn); err != nil {
+			return nil, err
 		}
+		return s.Processes, nil
+	}
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
 	}
+	defer fd.Close()
+	s, err := procfile.Parse(fd)
+	if err != nil {
+		return nil, err
+	}
+	return s.Processes, nil
+}
 
+// selectProcs applies the -skip and -only flags to the process types.
+func selectProcs(procs []*runner.ProcessType) []*runner.ProcessType {
 	if *skipProcs != "" {
-		s.Processes = filterSkippedProcs(*skipProcs, s.Processes)
+		procs = filterSkippedProcs(*skipProcs, procs)
 	} else if *onlyProcs != "" {
-		s.Processes = filterOnlyProcs(*onlyProcs, s.Processes)
-	}
-	s.ServiceDiscoveryAddr = *discoveryAddr
-	if err := s.Start(ctx); err != nil {
-		log.Fatalln("cannot serve:", err)
+		procs = filterOnlyProcs(*onlyProcs, procs)
 	}
+	filterDependencies(procs)
+	return procs
 }
 
 func filterSkippedProcs(skip string, processes []*runner.ProcessType) []*runner.ProcessType {
@@ -262,3 +333,31 @@ procTypes:
 	}
 	return newProcs
 }
+
+// filterDependencies drops the dependencies on the process types that are not
+// going to run, so the builds depending on skipped ones are not held back.
+func filterDependencies(processes []*runner.ProcessType) {
+	declared := make(map[string]bool)
+	for _, procType := range processes {
+		declared[procType.Name] = true
+	}
+	for _, procType := range processes {
+		var deps []string
+		for _, dep := range procType.DependsOn {
+			if declared[dep] {
+				deps = append(deps, dep)
+			}
+		}
+		procType.DependsOn = deps
+	}
+}
+
+func filterFormation(formation map[string]int, processes []*runner.ProcessType) map[string]int {
+	newFormation := make(map[string]int)
+	for _, procType := range processes {
+		if count, ok := formation[procType.Name]; ok {
+			newFormation[procType.Name] = count
+		}
+	}
+	return newFormation
+}
@@ -38,9 +38,9 @@
 // typically vendor directories.
 //
 // - formation: allows to start more than one instance for a given process type.
-// if the process type is declared with zero ("proc=0"), it is not started. Non
-// declared process types are started once. Each process type has its own
-// exclusive $PORT variable value.
+// Non declared process types are started once. Each process type has its own
+// exclusive $PORT variable value. Formations must refer to declared non-build
+// process types, and have at least one instance.
 //
 // - waitfor (in process type): target hostname and port that the runner will
 // probe before starting the process type.
@@ -72,7 +72,7 @@ import (
 )
 
 // Parse takes a reader that contains an extended Procfile.
-func Parse(r io.Reader) (runner.Runner, error) {
+func Parse(r io.Reader) (*runner.Runner, error) {
 	rnr := runner.New()
 
 	scanner := bufio.NewScanner(r)
@@ -130,14 +130,18 @@ func Parse(r io.Reader) (runner.Runner, error) {
 				if strings.HasPrefix(part, "sticky=") {
 					sticky, err := strconv.ParseBool(strings.TrimPrefix(part, "sticky="))
 					if err != nil {
-						return rnr, err
+						return nil, err
 					}
 					proc.Sticky = sticky
 					continue
 				}
 				if strings.HasPrefix(part, "restart=") {
 					restartMode := strings.TrimPrefix(part, "restart=")
-					proc.Restart = runner.ParseRestartMode(restartMode)
+					mode, err := runner.ParseRestartModeStrict(restartMode)
+					if err != nil {
+						return nil, err
+					}
+					proc.Restart = mode
 					continue
 				}
 				if strings.HasPrefix(part, "group=") {
@@ -151,5 +155,8 @@ func Parse(r io.Reader) (runner.Runner, error) {
 		}
 	}
 
-	return rnr, scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &rnr, nil
 }
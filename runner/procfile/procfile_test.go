@@ -78,8 +78,8 @@ malformed-line`
 		"web2": 2,
 	}
 
-	if !reflect.DeepEqual(&got, &expected) {
-		t.Errorf("parser did not get the right result. got: %#v\nexpected:%#v", &got, &expected)
+	if !reflect.DeepEqual(got, &expected) {
+		t.Errorf("parser did not get the right result. got: %#v\nexpected:%#v", got, &expected)
 	}
 }
 
@@ -110,4 +110,9 @@ func TestParseErrors(t *testing.T) {
 	if l := len(got.Formation); l != 0 {
 		t.Error("empty formation lines should result in empty formations maps, got:", l)
 	}
+
+	example = `web: restart=onfailuer ./server serve`
+	if _, err := Parse(strings.NewReader(example)); err == nil {
+		t.Error("unknown restart modes should be rejected")
+	}
 }
@@ -0,0 +1,59 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCPUAffinity(t *testing.T) {
+	var available unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &available); err != nil {
+		t.Skip("cannot read the CPU affinity:", err)
+	}
+	cpu := -1
+	for i := 0; i < maxCPU; i++ {
+		if available.IsSet(i) {
+			cpu = i
+			break
+		}
+	}
+
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}, CPUAffinity: []int{cpu}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	var got unix.CPUSet
+	ok := eventually(t, func() bool {
+		r.liveMu.Lock()
+		p, ok := r.live["web.0"]
+		r.liveMu.Unlock()
+		if !ok || unix.SchedGetaffinity(p.Pid, &got) != nil {
+			return false
+		}
+		return got.Count() == 1
+	})
+	if !ok {
+		t.Fatal("CPU affinity not applied, got", got.Count(), "cores")
+	}
+	if !got.IsSet(cpu) {
+		t.Error("process pinned to the wrong CPU core")
+	}
+}
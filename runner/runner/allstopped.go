@@ -0,0 +1,72 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrProcessesFailed is returned by Start, with ExitWhenAllStopped, when any
+// of the processes did not exit successfully.
+var ErrProcessesFailed = errors.New("processes failed")
+
+// stopTracker follows the instances of a generation until all of them have
+// stopped for good, to implement ExitWhenAllStopped.
+type stopTracker struct {
+	mu        sync.Mutex
+	remaining int
+	failed    []string
+	done      chan<- error
+}
+
+func newStopTracker(instances int, done chan<- error) *stopTracker {
+	t := &stopTracker{remaining: instances, done: done}
+	t.check()
+	return t
+}
+
+// stopped records that the instance exited and is not going to be restarted,
+// successfully unless err is set.
+func (t *stopTracker) stopped(procName string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remaining--
+	if err != nil {
+		t.failed = append(t.failed, fmt.Sprintf("%s (%v)", procName, err))
+	}
+	t.check()
+}
+
+func (t *stopTracker) check() {
+	if t.remaining > 0 {
+		return
+	}
+	var err error
+	if len(t.failed) > 0 {
+		sort.Strings(t.failed)
+		err = fmt.Errorf("%w: %s", ErrProcessesFailed, strings.Join(t.failed, ", "))
+	}
+	select {
+	case t.done <- err:
+	default:
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitWhenAllStopped(t *testing.T) {
+	for name, tt := range map[string]struct {
+		procs   []*ProcessType
+		wantErr string
+	}{
+		"success": {
+			procs: []*ProcessType{
+				{Name: "migrate", Cmd: []string{"sleep 0.2"}},
+				{Name: "seed", Cmd: []string{"sleep 0.4"}},
+			},
+		},
+		"failure": {
+			procs: []*ProcessType{
+				{Name: "migrate", Cmd: []string{"sleep 0.2"}},
+				{Name: "seed", Cmd: []string{"sleep 0.4; exit 3"}},
+			},
+			wantErr: "processes failed: seed.0 (exit status 3)",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = tempDir(t)
+			r.ExitWhenAllStopped = true
+			r.Processes = tt.procs
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			err := r.Start(ctx)
+			if ctx.Err() != nil {
+				t.Fatal("Start did not return once the processes stopped")
+			}
+			if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+				t.Error("Start returned before all processes stopped:", elapsed)
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Error("unexpected error:", err)
+			case tt.wantErr != "" && (!errors.Is(err, ErrProcessesFailed) || err.Error() != tt.wantErr):
+				t.Errorf("unexpected error: %v, want: %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateExitWhenAllStopped(t *testing.T) {
+	r := New()
+	r.ExitWhenAllStopped = true
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"make"}},
+		{Name: "web", Cmd: []string{"./web"}, Restart: Always},
+	}
+	if err := r.Validate(); err == nil || !strings.Contains(err.Error(), "ExitWhenAllStopped") {
+		t.Error("process types restarting always should be rejected:", err)
+	}
+}
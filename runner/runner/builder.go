@@ -0,0 +1,128 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ProcessOption configures a process type added with AddProcess.
+type ProcessOption func(*ProcessType)
+
+// WithCmd sets the commands of the process type.
+func WithCmd(cmds ...string) ProcessOption {
+	return func(p *ProcessType) { p.Cmd = append(p.Cmd, cmds...) }
+}
+
+// WithWaitBefore sets the target the process type waits for before
+// executing its first command.
+func WithWaitBefore(target string) ProcessOption {
+	return func(p *ProcessType) { p.WaitBefore = target }
+}
+
+// WithWaitFor sets the target the process type waits for before executing its
+// last command.
+func WithWaitFor(target string) ProcessOption {
+	return func(p *ProcessType) { p.WaitFor = target }
+}
+
+// WithRestart sets the restart mode of the process type.
+func WithRestart(mode RestartMode) ProcessOption {
+	return func(p *ProcessType) { p.Restart = mode }
+}
+
+// WithRestartExitCodes sets the exit codes that restart the process type.
+func WithRestartExitCodes(codes ...int) ProcessOption {
+	return func(p *ProcessType) { p.RestartExitCodes = append(p.RestartExitCodes, codes...) }
+}
+
+// WithGroup sets the supervisor group of the process type.
+func WithGroup(group string) ProcessOption {
+	return func(p *ProcessType) { p.Group = group }
+}
+
+// WithSticky marks the process type as not interruptible by filesystem
+// events.
+func WithSticky() ProcessOption {
+	return func(p *ProcessType) { p.Sticky = true }
+}
+
+// WithDependsOn adds build process types that must succeed before the build
+// process type starts.
+func WithDependsOn(names ...string) ProcessOption {
+	return func(p *ProcessType) { p.DependsOn = append(p.DependsOn, names...) }
+}
+
+// WithLivenessProbe sets the liveness probe of the process type.
+func WithLivenessProbe(probe Probe) ProcessOption {
+	return func(p *ProcessType) { p.LivenessProbe = &probe }
+}
+
+// WithReload sets the signal sent to the process type when files matching
+// the given patterns change.
+func WithReload(signal string, patterns ...string) ProcessOption {
+	return func(p *ProcessType) {
+		p.ReloadSignal = signal
+		p.ReloadObservables = append(p.ReloadObservables, patterns...)
+	}
+}
+
+// WithEnvFiles adds environment files loaded for the process type only.
+func WithEnvFiles(fns ...string) ProcessOption {
+	return func(p *ProcessType) { p.EnvFiles = append(p.EnvFiles, fns...) }
+}
+
+// WithEnv adds environment variables, in the format VARIABLENAME=VALUE, set
+// for the process type only.
+func WithEnv(env ...string) ProcessOption {
+	return func(p *ProcessType) { p.Env = append(p.Env, env...) }
+}
+
+// AddProcess declares a new process type in the runner. Unlike appending to
+// Processes directly, it rejects empty names, duplicated names (including
+// names that collide once normalized into environment variables) and process
+// types without commands, so mistakes are reported before Start.
+func (r *Runner) AddProcess(name string, opts ...ProcessOption) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("process type name cannot be empty")
+	}
+	normalized := normalizeByEnvVarRules(name)
+	for _, proc := range r.Processes {
+		if normalizeByEnvVarRules(proc.Name) == normalized {
+			return fmt.Errorf("%s: %w", name, ErrNonUniqueProcessTypeName)
+		}
+	}
+	proc := &ProcessType{Name: name}
+	for _, opt := range opts {
+		opt(proc)
+	}
+	if len(proc.Cmd) == 0 {
+		return fmt.Errorf("%s: missing command", name)
+	}
+	for i, cmd := range proc.Cmd {
+		if strings.TrimSpace(cmd) == "" {
+			return fmt.Errorf("%s: command #%d is empty", name, i+1)
+		}
+	}
+	if len(proc.ReloadObservables) > 0 {
+		if _, err := parseSignal(proc.ReloadSignal); err != nil {
+			return fmt.Errorf("%s: invalid reload signal: %v", name, err)
+		}
+	}
+	r.Processes = append(r.Processes, proc)
+	return nil
+}
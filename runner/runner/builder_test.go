@@ -0,0 +1,62 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAddProcess(t *testing.T) {
+	r := New()
+	if err := r.AddProcess("web", WithCmd("make build", "exec ./web"), WithWaitFor("db"), WithRestart(Always)); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := r.AddProcess("db", WithCmd("exec ./db"), WithSticky()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(r.Processes) != 2 {
+		t.Fatal("unexpected number of process types:", len(r.Processes))
+	}
+	web := r.Processes[0]
+	if web.Name != "web" || len(web.Cmd) != 2 || web.WaitFor != "db" || web.Restart != Always {
+		t.Errorf("options not applied: %#v", web)
+	}
+
+	errCases := []struct {
+		name string
+		opts []ProcessOption
+	}{
+		{"", []ProcessOption{WithCmd("true")}},
+		{"worker", nil},
+		{"worker", []ProcessOption{WithCmd("true", " ")}},
+		{"worker", []ProcessOption{WithCmd("true"), WithReload("NOTASIGNAL", "*.conf")}},
+	}
+	for _, tc := range errCases {
+		if err := r.AddProcess(tc.name, tc.opts...); err == nil {
+			t.Errorf("AddProcess(%q) should have failed", tc.name)
+		}
+	}
+
+	for _, name := range []string{"web", "WEB"} {
+		err := r.AddProcess(name, WithCmd("true"))
+		if !errors.Is(err, ErrNonUniqueProcessTypeName) {
+			t.Errorf("AddProcess(%q) should have rejected the duplicated name, got: %v", name, err)
+		}
+	}
+	if len(r.Processes) != 2 {
+		t.Error("rejected process types should not be added:", len(r.Processes))
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateBuildGraph checks that the DependsOn of the process types only name
+// other build process types, and that they do not form cycles.
+func validateBuildGraph(procs []*ProcessType) error {
+	builds := make(map[string]*ProcessType)
+	for _, proc := range procs {
+		if isBuild(proc) {
+			builds[proc.Name] = proc
+		}
+	}
+	for _, proc := range procs {
+		if len(proc.DependsOn) > 0 && !isBuild(proc) {
+			return fmt.Errorf("%s: dependencies apply only to build process types", proc.Name)
+		}
+		for _, dep := range proc.DependsOn {
+			if _, ok := builds[dep]; !ok {
+				return fmt.Errorf("%s: depends on unknown build process type %q", proc.Name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, step := range path {
+				if step == name {
+					cycle := append(path[i:len(path):len(path)], name)
+					return fmt.Errorf("build dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range builds[name].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, proc := range procs {
+		if !isBuild(proc) {
+			continue
+		}
+		if err := visit(proc.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DependencyGraph describes the process types and their dependencies in the
+// DOT language of Graphviz, for instance to render it with dot. Each process
+// type has an edge to the build process types of its DependsOn, and to the
+// process types its WaitBefore and WaitFor target by name or instance name
+// (e.g. "db" or "db.0"), labeled after the field declaring them. Other wait
+// targets, like network addresses, are left out.
+func (r *Runner) DependencyGraph() string {
+	var b strings.Builder
+	b.WriteString("digraph runner {\n")
+	for _, proc := range r.processes() {
+		fmt.Fprintf(&b, "\t%q;\n", proc.Name)
+	}
+	for _, proc := range r.processes() {
+		for _, dep := range proc.DependsOn {
+			fmt.Fprintf(&b, "\t%q -> %q [label=\"dependson\"];\n", proc.Name, dep)
+		}
+		waits := []struct{ label, target string }{
+			{"waitbefore", proc.WaitBefore},
+			{"waitfor", proc.WaitFor},
+		}
+		for _, wait := range waits {
+			if dep, ok := r.waitTargetProcessType(wait.target); ok {
+				fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", proc.Name, dep, wait.label)
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// waitTargetProcessType finds the process type that the wait target names,
+// either directly or through one of its instances.
+func (r *Runner) waitTargetProcessType(target string) (string, bool) {
+	name, _, ok := r.waitTargetInstance(target)
+	return name, ok
+}
+
+// waitTargetInstance finds the process type and the instance that the wait
+// target names. Process type names stand for their first instance.
+func (r *Runner) waitTargetInstance(target string) (name, instance string, ok bool) {
+	if target == "" {
+		return "", "", false
+	}
+	if expanded, err := r.expandEnv(target); err == nil {
+		target = expanded
+	}
+	for _, proc := range r.processes() {
+		if target == proc.Name {
+			return proc.Name, proc.Name + ".0", true
+		}
+	}
+	for _, proc := range r.processes() {
+		if strings.HasPrefix(target, proc.Name+".") {
+			return proc.Name, target, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildDependencyDiamond(t *testing.T) {
+	const step = `echo "start $PS" >> steps.log; sleep 0.2; echo "end $PS" >> steps.log`
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-package", Cmd: []string{step}, DependsOn: []string{"build-compile-a", "build-compile-b"}},
+		{Name: "build-compile-a", Cmd: []string{step}, DependsOn: []string{"build-proto"}},
+		{Name: "build-compile-b", Cmd: []string{step}, DependsOn: []string{"build-proto"}},
+		{Name: "build-proto", Cmd: []string{step}},
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) })
+	stop()
+	if !ok {
+		t.Fatal("builds did not complete")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.WorkDir, "steps.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := make(map[string]int)
+	for i, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		at[line] = i
+	}
+	if len(at) != 8 {
+		t.Fatalf("unexpected steps: %q", b)
+	}
+	before := func(first, then string) {
+		t.Helper()
+		if at[first] > at[then] {
+			t.Errorf("%q should happen before %q: %q", first, then, b)
+		}
+	}
+	for _, compile := range []string{"build-compile-a", "build-compile-b"} {
+		before("end build-proto", "start "+compile)
+		before("end "+compile, "start build-package")
+	}
+	before("start build-compile-a", "end build-compile-b")
+	before("start build-compile-b", "end build-compile-a")
+}
+
+func TestBuildDependencyFailure(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-proto", Cmd: []string{"exit 1"}},
+		{Name: "build-compile", Cmd: []string{`touch "$PS"`}, DependsOn: []string{"build-proto"}},
+		{Name: "build-docs", Cmd: []string{`touch "$PS"`}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { _, when := r.LastBuildStatus(); return !when.IsZero() })
+	stop()
+	if !ok {
+		t.Fatal("builds did not complete")
+	}
+	if ok, _ := r.LastBuildStatus(); ok {
+		t.Error("the build should have failed")
+	}
+	if fileExists(filepath.Join(r.WorkDir, "build-compile")) {
+		t.Error("build-compile should have been skipped")
+	}
+	if !fileExists(filepath.Join(r.WorkDir, "build-docs")) {
+		t.Error("build-docs does not depend on build-proto and should have run")
+	}
+}
+
+func TestValidateBuildDependencyCycle(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-proto", Cmd: []string{"true"}},
+		{Name: "build-a", Cmd: []string{"true"}, DependsOn: []string{"build-proto", "build-b"}},
+		{Name: "build-b", Cmd: []string{"true"}, DependsOn: []string{"build-c"}},
+		{Name: "build-c", Cmd: []string{"true"}, DependsOn: []string{"build-a"}},
+	}
+	err := r.Validate()
+	if err == nil {
+		t.Fatal("expected error missing")
+	}
+	const want = "build dependency cycle: build-a -> build-b -> build-c -> build-a"
+	if err.Error() != want {
+		t.Errorf("unexpected error message. got: %q, want: %q", err, want)
+	}
+}
+
+func TestValidateBuildDependencies(t *testing.T) {
+	for name, procs := range map[string][]*ProcessType{
+		"unknown": {
+			{Name: "build-a", Cmd: []string{"true"}, DependsOn: []string{"build-missing"}},
+		},
+		"non-build dependency": {
+			{Name: "web", Cmd: []string{"true"}},
+			{Name: "build-a", Cmd: []string{"true"}, DependsOn: []string{"web"}},
+		},
+		"non-build dependent": {
+			{Name: "build-a", Cmd: []string{"true"}},
+			{Name: "web", Cmd: []string{"true"}, DependsOn: []string{"build-a"}},
+		},
+	} {
+		r := New()
+		r.Processes = procs
+		if err := r.Validate(); err == nil {
+			t.Errorf("%s: expected error missing", name)
+		}
+	}
+}
+
+func TestDependencyGraph(t *testing.T) {
+	r := New()
+	r.SetBaseEnvironment([]string{"CACHE=cache"})
+	r.Processes = []*ProcessType{
+		{Name: "build-server", Cmd: []string{"make server"}, DependsOn: []string{"build-proto"}},
+		{Name: "build-proto", Cmd: []string{"make proto"}},
+		{Name: "db", Cmd: []string{"postgres"}},
+		{Name: "cache", Cmd: []string{"redis-server"}},
+		{Name: "web", Cmd: []string{"./server"}, WaitBefore: "db.0", WaitFor: "$CACHE"},
+		{Name: "worker", Cmd: []string{"./worker"}, WaitFor: "localhost:6379"},
+	}
+	want := `digraph runner {
+	"build-server";
+	"build-proto";
+	"db";
+	"cache";
+	"web";
+	"worker";
+	"build-server" -> "build-proto" [label="dependson"];
+	"web" -> "db" [label="waitbefore"];
+	"web" -> "cache" [label="waitfor"];
+}
+`
+	if got := r.DependencyGraph(); got != want {
+		t.Errorf("unexpected dependency graph:\n%s\nwant:\n%s", got, want)
+	}
+}
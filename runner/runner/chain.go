@@ -0,0 +1,161 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrStageStopped is returned by Chain when the runner of a stage stops
+// before the chain is done with it.
+var ErrStageStopped = errors.New("stage stopped")
+
+// StageUntil is the state that the runner of a stage must reach before the
+// next stage starts.
+type StageUntil int
+
+// States of StageUntil.
+const (
+	// UntilAllReady waits for all the process instances of the runner to
+	// be ready. The runner keeps running while the next stages start.
+	UntilAllReady StageUntil = iota
+	// UntilAllStopped waits for all the process instances of the runner
+	// to stop successfully, as with ExitWhenAllStopped.
+	UntilAllStopped
+)
+
+// Stage is a step of Chain.
+type Stage struct {
+	// Runner is the runner of the stage. Chain sets it up for the stage
+	// before starting it: with UntilAllStopped, its ExitWhenAllStopped is
+	// set. It must not be started on its own, nor be part of another
+	// chain.
+	Runner *Runner
+	Until  StageUntil
+}
+
+// Chain starts the runners of the stages one after the other, each one once
+// the runner of the previous stage reaches its Until state. For instance, a
+// runner applying database migrations until all stopped, followed by a runner
+// serving the application. Runners with UntilAllReady must have at least one
+// non-build process type.
+//
+// The runners that run at the same time, that is, each runner and the ones of
+// the previous UntilAllReady stages, must be assigned different IP ports (see
+// BasePort).
+//
+// Chain returns once ctx is done, stopping all runners, or as soon as any of
+// them fails or stops on its own before the chain is done with it. If all
+// stages are UntilAllStopped, it returns once the last one stops.
+func Chain(ctx context.Context, stages ...Stage) error {
+	if err := validateStages(stages); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	failed := make(chan error, len(stages))
+	running := 0
+	for i, stage := range stages {
+		r := stage.Runner
+		reached := make(chan struct{})
+		switch stage.Until {
+		case UntilAllReady:
+			var once sync.Once
+			r.onAllReady = func() { once.Do(func() { close(reached) }) }
+		case UntilAllStopped:
+			r.ExitWhenAllStopped = true
+		}
+		exited := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exited <- r.Start(ctx)
+		}()
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-failed:
+			return err
+		case err := <-exited:
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == nil && stage.Until == UntilAllReady {
+				err = ErrStageStopped
+			}
+			if err != nil {
+				return fmt.Errorf("stage %d: %w", i, err)
+			}
+		case <-reached:
+			running++
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := <-exited
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil {
+					err = ErrStageStopped
+				}
+				failed <- fmt.Errorf("stage %d: %w", i, err)
+			}(i)
+		}
+	}
+	if running == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-failed:
+		return err
+	}
+}
+
+// validateStages checks the states of the stages, and that the runners that
+// run at the same time do not share IP ports.
+func validateStages(stages []Stage) error {
+	taken := make(map[int]string) // port to the process of a running stage
+	for i, stage := range stages {
+		if stage.Until != UntilAllReady && stage.Until != UntilAllStopped {
+			return fmt.Errorf("stage %d: unknown state %d", i, stage.Until)
+		}
+		ports := stage.Runner.assignedPorts()
+		sorted := make([]int, 0, len(ports))
+		for port := range ports {
+			sorted = append(sorted, port)
+		}
+		sort.Ints(sorted)
+		for _, port := range sorted {
+			if other, ok := taken[port]; ok {
+				return fmt.Errorf("stage %d: %s is assigned IP port %d, already assigned to %s", i, ports[port], port, other)
+			}
+		}
+		if stage.Until == UntilAllReady {
+			for port, name := range ports {
+				taken[port] = fmt.Sprintf("%s of stage %d", name, i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	dir := tempDir(t)
+	setup := New()
+	setup.WorkDir = dir
+	setup.Processes = []*ProcessType{
+		{Name: "migrate", Cmd: []string{"sleep 0.2; touch migrated"}},
+		{Name: "seed", Cmd: []string{"sleep 0.1; touch seeded"}},
+	}
+	serve := New()
+	serve.WorkDir = dir
+	serve.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`test -f migrated && test -f seeded && touch "$PS"; exec sleep 30`}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Chain(ctx, Stage{Runner: &setup, Until: UntilAllStopped}, Stage{Runner: &serve, Until: UntilAllReady})
+	}()
+	if !eventually(t, func() bool { return fileExists(filepath.Join(dir, "web.0")) }) {
+		t.Error("web should have started after the setup completed")
+	}
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the chain did not stop")
+	}
+}
+
+func TestChainStageFailure(t *testing.T) {
+	dir := tempDir(t)
+	setup := New()
+	setup.WorkDir = dir
+	setup.Processes = []*ProcessType{
+		{Name: "migrate", Cmd: []string{"exit 1"}},
+	}
+	serve := New()
+	serve.WorkDir = dir
+	serve.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}},
+	}
+	err := Chain(context.Background(), Stage{Runner: &setup, Until: UntilAllStopped}, Stage{Runner: &serve})
+	if !errors.Is(err, ErrProcessesFailed) {
+		t.Error("unexpected error:", err)
+	}
+	if fileExists(filepath.Join(dir, "web.0")) {
+		t.Error("web should not have started after the setup failed")
+	}
+}
+
+func TestChainOverlappingPorts(t *testing.T) {
+	db := New()
+	db.Processes = []*ProcessType{{Name: "db", Cmd: []string{"exec sleep 30"}}}
+	web := New()
+	web.Processes = []*ProcessType{{Name: "web", Cmd: []string{"exec sleep 30"}}}
+	err := Chain(context.Background(), Stage{Runner: &db}, Stage{Runner: &web})
+	if err == nil || !strings.Contains(err.Error(), "web.0 is assigned IP port 5000, already assigned to db.0 of stage 0") {
+		t.Error("runners running at the same time should not share IP ports, got:", err)
+	}
+}
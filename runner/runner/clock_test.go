@@ -0,0 +1,164 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only passes when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward, firing the timers due by then.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+// waiting reports whether a timer is due at the deadline.
+func (c *fakeClock) waiting(deadline time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.timers {
+		if t.deadline.Equal(deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestFakeClockWaitTimeout(t *testing.T) {
+	clock := newFakeClock()
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.timeSource = clock
+	r.WaitTimeout = time.Hour
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+		{Name: "worker", Cmd: []string{"exec sleep 30"}, WaitFor: "localhost:1"},
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- r.Start(context.Background()) }()
+
+	deadline := clock.Now().Add(r.WaitTimeout)
+	if !eventually(t, func() bool { return r.readiness.isReady("web.0") && clock.waiting(deadline) }) {
+		t.Fatal("the wait timeout did not start")
+	}
+	select {
+	case err := <-errc:
+		t.Fatal("Start should not fail before the time passes:", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	clock.Advance(r.WaitTimeout)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrWaitTimeout) {
+			t.Fatal("unexpected error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start should have failed as soon as the wait timeout was over")
+	}
+}
+
+func TestFakeClockMaxRuntime(t *testing.T) {
+	clock := newFakeClock()
+	r := New()
+	var out syncBuffer
+	r.MetaOutput = &out
+	r.timeSource = clock
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}, MaxRuntime: time.Hour},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	deadline := clock.Now().Add(time.Hour)
+	if !eventually(t, func() bool { return clock.waiting(deadline) }) {
+		t.Fatal("the maximum runtime did not start")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(out.String(), "maximum runtime") {
+		t.Fatal("the maximum runtime should not be reached before the time passes")
+	}
+	clock.Advance(time.Hour)
+	if !eventually(t, func() bool { return strings.Contains(out.String(), "maximum runtime of 1h0m0s reached, stopping") }) {
+		t.Error("the instance should be stopped as soon as the maximum runtime is over")
+	}
+}
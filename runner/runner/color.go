@@ -0,0 +1,78 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+var colorPalette = []string{
+	"\x1b[36m", // cyan
+	"\x1b[33m", // yellow
+	"\x1b[32m", // green
+	"\x1b[35m", // magenta
+	"\x1b[34m", // blue
+	"\x1b[91m", // bright red
+	"\x1b[96m", // bright cyan
+	"\x1b[93m", // bright yellow
+}
+
+const colorReset = "\x1b[0m"
+
+// colorEnabled decides whether the output written to w should be colorized.
+// NO_COLOR (https://no-color.org) always disables colors. FORCE_COLOR and
+// CLICOLOR_FORCE enable them even when w is not a terminal. Otherwise, colors
+// are used only when w is a terminal.
+func colorEnabled(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if envFlag("FORCE_COLOR") || envFlag("CLICOLOR_FORCE") {
+		return true
+	}
+	return isTerminal(w)
+}
+
+// envFlag tells whether the environment variable is set to anything other than
+// empty, "0" or "false".
+func envFlag(name string) bool {
+	switch os.Getenv(name) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps the prefix with a color picked from the process type name, so
+// the same process type keeps the same color across restarts.
+func colorize(name, prefix string) string {
+	h := fnv.New32a()
+	io.WriteString(h, name)
+	return colorPalette[h.Sum32()%uint32(len(colorPalette))] + prefix + colorReset
+}
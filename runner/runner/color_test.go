@@ -0,0 +1,93 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+			return
+		}
+		os.Unsetenv(key)
+	})
+}
+
+func TestColorEnabled(t *testing.T) {
+	for _, env := range []string{"NO_COLOR", "FORCE_COLOR", "CLICOLOR_FORCE"} {
+		setenv(t, env, "")
+	}
+	var buf bytes.Buffer
+
+	if colorEnabled(&buf) {
+		t.Error("non-TTY writers should not be colorized by default")
+	}
+
+	t.Run("FORCE_COLOR", func(t *testing.T) {
+		setenv(t, "FORCE_COLOR", "1")
+		if !colorEnabled(&buf) {
+			t.Error("FORCE_COLOR should enable colors on non-TTY writers")
+		}
+	})
+
+	t.Run("CLICOLOR_FORCE", func(t *testing.T) {
+		setenv(t, "CLICOLOR_FORCE", "1")
+		if !colorEnabled(&buf) {
+			t.Error("CLICOLOR_FORCE should enable colors on non-TTY writers")
+		}
+	})
+
+	t.Run("NO_COLOR", func(t *testing.T) {
+		setenv(t, "NO_COLOR", "1")
+		setenv(t, "FORCE_COLOR", "1")
+		if colorEnabled(&buf) {
+			t.Error("NO_COLOR should take precedence over FORCE_COLOR")
+		}
+	})
+}
+
+func TestColorizeProcessType(t *testing.T) {
+	setenv(t, "NO_COLOR", "")
+	setenv(t, "FORCE_COLOR", "1")
+	r := New()
+	sv := &ProcessType{Name: "web"}
+	r.Processes = []*ProcessType{sv}
+	var buf bytes.Buffer
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("web.%d", i)
+		r.linePrinter(sv, name, name, &buf)("up")
+	}
+	want := colorize("web", "")
+	want = want[:len(want)-len(colorReset)]
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, want) {
+			t.Errorf("all instances should share the color of the process type, got: %q", line)
+		}
+	}
+}
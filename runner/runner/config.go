@@ -0,0 +1,282 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Load decodes into r the JSON configuration file fn, merging the process
+// types of the files it includes (see Include). Process types declared more
+// than once across the files are reported as errors, and so are include
+// cycles. Once loaded, Include is cleared.
+func (r *Runner) Load(fn string) error {
+	if err := decodeConfig(fn, r); err != nil {
+		return err
+	}
+	declaredIn := make(map[string]string) // map of process type name to its file
+	for _, proc := range r.Processes {
+		if prev, ok := declaredIn[proc.Name]; ok {
+			return fmt.Errorf("%s: process type %q declared twice in %s", fn, proc.Name, prev)
+		}
+		declaredIn[proc.Name] = fn
+	}
+	includes := r.Include
+	r.Include = nil
+	procs, err := loadIncludes(fn, includes, declaredIn, []string{fn})
+	if err != nil {
+		return err
+	}
+	r.Processes = append(r.Processes, procs...)
+	return nil
+}
+
+// loadIncludes reads the process types of the files included by fn. chain is
+// the sequence of files that led to fn, used to detect include cycles.
+func loadIncludes(fn string, includes []string, declaredIn map[string]string, chain []string) ([]*ProcessType, error) {
+	var procs []*ProcessType
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(fn), include)
+		}
+		for _, prev := range chain {
+			if sameFile(prev, include) {
+				return nil, fmt.Errorf("%s: include cycle through %s", fn, include)
+			}
+		}
+		var included Runner
+		if err := decodeConfig(include, &included); err != nil {
+			return nil, err
+		}
+		for _, proc := range included.Processes {
+			if prev, ok := declaredIn[proc.Name]; ok {
+				return nil, fmt.Errorf("%s: process type %q already declared in %s", include, proc.Name, prev)
+			}
+			declaredIn[proc.Name] = include
+		}
+		procs = append(procs, included.Processes...)
+		nested, err := loadIncludes(include, included.Include, declaredIn, append(chain[:len(chain):len(chain)], include))
+		if err != nil {
+			return nil, err
+		}
+		procs = append(procs, nested...)
+	}
+	return procs, nil
+}
+
+func decodeConfig(fn string, r *Runner) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(r); err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	return nil
+}
+
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// isConfigFile reports whether fn is the configuration file watched with
+// WatchConfig.
+func (r *Runner) isConfigFile(fn string) bool {
+	return r.WatchConfig && fn != "" && sameFile(fn, r.ConfigPath)
+}
+
+// reloadConfig replaces the process types with the ones of ConfigPath, as
+// read by LoadConfig. If they cannot be read or are not valid, the current
+// process types are kept.
+func (r *Runner) reloadConfig() error {
+	load := r.LoadConfig
+	if load == nil {
+		load = func(fn string) ([]*ProcessType, error) {
+			var next Runner
+			if err := next.Load(fn); err != nil {
+				return nil, err
+			}
+			return next.Processes, nil
+		}
+	}
+	procs, err := load(r.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := r.validate(procs); err != nil {
+		return err
+	}
+	credentials, err := resolveCredentials(procs)
+	if err != nil {
+		return err
+	}
+	r.procsMu.Lock()
+	defer r.procsMu.Unlock()
+	r.Processes, r.credentials = procs, credentials
+	return nil
+}
+
+// effectiveConfig is the JSON encoding of Runner, with the defaults and the
+// computed values filled in. The fields declared here take precedence over the
+// ones of the embedded Runner.
+type effectiveConfig struct {
+	*runnerConfig
+	WorkDir         string           `json:"workdir"`
+	Processes       []*ProcessType   `json:"procs"`
+	BasePort        int              `json:"BasePort"`
+	Formation       map[string]int   `json:"Formation"`
+	BaseEnvironment []string         `json:"BaseEnvironment"`
+	Instances       []configInstance `json:"instances"`
+}
+
+// runnerConfig is a Runner without its methods, so it encodes as a plain
+// struct.
+type runnerConfig Runner
+
+// configInstance is an instance of a non-build process type, as it is going
+// to be started.
+type configInstance struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// MarshalConfig encodes the effective configuration of the runner as JSON. It
+// can be decoded back into a Runner, and differs from encoding the Runner
+// directly in that the working directory is resolved into an absolute path,
+// the formation lists every non-build process type (including the ones that
+// default to a single instance) and the instances are listed along with their
+// IP ports.
+func (r *Runner) MarshalConfig() ([]byte, error) {
+	workDir := r.WorkDir
+	if workDir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		workDir = wd
+	}
+	workDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	formation := make(map[string]int)
+	for _, proc := range r.processes() {
+		if !isBuild(proc) {
+			formation[proc.Name] = r.formationCount(proc)
+		}
+	}
+	instances := []configInstance{}
+	for _, inst := range r.plan() {
+		instances = append(instances, configInstance{Name: inst.name, Port: inst.port})
+	}
+
+	return json.MarshalIndent(effectiveConfig{
+		runnerConfig:    (*runnerConfig)(r),
+		WorkDir:         workDir,
+		Processes:       r.processes(),
+		BasePort:        r.basePort(),
+		Formation:       formation,
+		BaseEnvironment: r.baseEnvironment(),
+		Instances:       instances,
+	}, "", "    ")
+}
+
+// shellCommand is the command line with which sh interprets cmd, one of the
+// commands of sv.
+func (r *Runner) shellCommand(sv *ProcessType, cmd string) string {
+	umask := r.Umask
+	if sv.Umask != nil {
+		umask = sv.Umask
+	}
+	return umaskCommand(umask, limitCommand(sv.Limits, execCommand(cmd)))
+}
+
+// shellKeywords are the words that cannot follow exec, either because they
+// start compound commands or because they are shell builtins.
+var shellKeywords = map[string]bool{
+	"!": true, "{": true, "[[": true, "case": true, "for": true,
+	"function": true, "if": true, "select": true, "until": true,
+	"while": true, ".": true, "cd": true, "eval": true, "exec": true,
+	"exit": true, "export": true, "read": true, "set": true,
+	"source": true, "trap": true, "unset": true, "wait": true,
+}
+
+// execCommand prefixes cmd with exec when it is a simple command, so that sh
+// is replaced by the process instead of waiting for it, and the signals sent
+// to the instance reach the process rather than its shell. Commands with
+// lists, pipelines, subshells or substitutions are left as they are.
+func execCommand(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 || shellKeywords[fields[0]] || strings.Contains(fields[0], "=") {
+		return cmd
+	}
+	redirects := strings.NewReplacer(">&", "", "<&", "", "&>", "")
+	if strings.ContainsAny(redirects.Replace(cmd), ";|&()`\n") {
+		return cmd
+	}
+	return "exec " + cmd
+}
+
+// commandArgs are the arguments with which cmd, one of the commands of sv, is
+// executed when there is no CommandFactory.
+func (r *Runner) commandArgs(sv *ProcessType, cmd string) []string {
+	return []string{"sh", "-c", r.shellCommand(sv, cmd)}
+}
+
+// command creates the command that runs shellCmd, as returned by
+// shellCommand, with the CommandFactory of the runner.
+func (r *Runner) command(ctx context.Context, shellCmd string) *exec.Cmd {
+	if r.CommandFactory == nil {
+		return exec.Command("sh", "-c", shellCmd)
+	}
+	return r.CommandFactory(ctx, "sh", shellCmd)
+}
+
+// EffectiveCommands lists, for each command of the named process type, the
+// arguments the runner executes it with, without running anything. Commands
+// are interpreted by sh, preceded by the umask call of the process type Umask
+// and by the ulimit calls of its Limits; simple commands are run with exec,
+// so that they replace the shell. The CommandFactory is not called, so
+// the wrappers it may add are not listed. It returns nil if there is no
+// process type with such name.
+func (r *Runner) EffectiveCommands(name string) [][]string {
+	for _, proc := range r.processes() {
+		if proc.Name != name {
+			continue
+		}
+		cmds := make([][]string, 0, len(proc.Cmd))
+		for _, cmd := range proc.Cmd {
+			cmds = append(cmds, r.commandArgs(proc, cmd))
+		}
+		return cmds
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalConfig(t *testing.T) {
+	r := New()
+	r.WorkDir = "."
+	r.WaitTimeout = time.Minute
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"make"}},
+		{Name: "web", Cmd: []string{"./web"}, WaitFor: "db"},
+		{Name: "db", Cmd: []string{"./db"}, Restart: Always},
+	}
+	r.Formation["web"] = 2
+
+	b, err := r.MarshalConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(b))
+
+	loaded := New()
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal("cannot load effective configuration:", err)
+	}
+	if !filepath.IsAbs(loaded.WorkDir) {
+		t.Error("WorkDir should be resolved into an absolute path:", loaded.WorkDir)
+	}
+	if loaded.BasePort != 5000 || loaded.WaitTimeout != time.Minute {
+		t.Error("defaults and settings missing from the configuration")
+	}
+	if want := map[string]int{"web": 2, "db": 1}; !reflect.DeepEqual(loaded.Formation, want) {
+		t.Errorf("unexpected formation. got: %v, want: %v", loaded.Formation, want)
+	}
+	if !reflect.DeepEqual(loaded.Processes, r.Processes) {
+		t.Error("process types do not round-trip")
+	}
+
+	var instances struct {
+		Instances []configInstance `json:"instances"`
+	}
+	if err := json.Unmarshal(b, &instances); err != nil {
+		t.Fatal(err)
+	}
+	want := []configInstance{{"web.0", 5100}, {"web.1", 5101}, {"db.0", 5200}}
+	if !reflect.DeepEqual(instances.Instances, want) {
+		t.Errorf("unexpected instances. got: %v, want: %v", instances.Instances, want)
+	}
+
+	again, err := loaded.MarshalConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, again) {
+		t.Errorf("effective configuration should be stable:\n%s\n%s", b, again)
+	}
+}
+
+func TestMarshalConfigFields(t *testing.T) {
+	r := New()
+	direct, err := json.Marshal(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	effective, err := r.MarshalConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var directFields, effectiveFields map[string]json.RawMessage
+	if err := json.Unmarshal(direct, &directFields); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(effective, &effectiveFields); err != nil {
+		t.Fatal(err)
+	}
+	for name := range directFields {
+		if _, ok := effectiveFields[name]; !ok {
+			t.Errorf("%s is missing from the effective configuration", name)
+		}
+	}
+}
+
+func TestEffectiveCommands(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"./web -addr :$PORT", `echo "done"`}},
+		{Name: "worker", Cmd: []string{"./worker"}, Limits: &Limits{CPU: time.Minute}},
+	}
+
+	want := [][]string{
+		{"sh", "-c", "exec ./web -addr :$PORT"},
+		{"sh", "-c", `exec echo "done"`},
+	}
+	if got := r.EffectiveCommands("web"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected commands for web. got: %q, want: %q", got, want)
+	}
+	want = [][]string{{"sh", "-c", "ulimit -t 60 && exec ./worker"}}
+	if got := r.EffectiveCommands("worker"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected commands for worker. got: %q, want: %q", got, want)
+	}
+	if got := r.EffectiveCommands("db"); got != nil {
+		t.Error("unexpected commands for an unknown process type:", got)
+	}
+}
+
+func TestExecCommand(t *testing.T) {
+	tests := []struct {
+		cmd, want string
+	}{
+		{"./server -addr :$PORT", "exec ./server -addr :$PORT"},
+		{"./server 2>&1 > out.log", "exec ./server 2>&1 > out.log"},
+		{"./server -name ${PS}", "exec ./server -name ${PS}"},
+		{"exec ./server", "exec ./server"},
+		{"PORT=8080 ./server", "PORT=8080 ./server"},
+		{"cd web && ./server", "cd web && ./server"},
+		{"./build; ./server", "./build; ./server"},
+		{"./server | tee out.log", "./server | tee out.log"},
+		{"./server &", "./server &"},
+		{"./server -name $(hostname)", "./server -name $(hostname)"},
+		{"while true; do sleep 1; done", "while true; do sleep 1; done"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := execCommand(tt.cmd); got != tt.want {
+			t.Errorf("execCommand(%q) = %q, want: %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func writeConfigs(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for fn, content := range files {
+		fn = filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadInclude(t *testing.T) {
+	dir := tempDir(t)
+	writeConfigs(t, dir, map[string]string{
+		"runner.json":           `{"workdir": "app", "procs": [{"name": "web", "cmd": ["./web"]}], "include": ["conf/workers.json", "conf/db.json"]}`,
+		"conf/workers.json":     `{"procs": [{"name": "worker", "cmd": ["./worker"]}], "include": ["nested/cron.json"]}`,
+		"conf/nested/cron.json": `{"procs": [{"name": "cron", "cmd": ["./cron"]}]}`,
+		"conf/db.json":          `{"workdir": "ignored", "procs": [{"name": "db", "cmd": ["./db"]}]}`,
+	})
+	var r Runner
+	if err := r.Load(filepath.Join(dir, "runner.json")); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, proc := range r.Processes {
+		names = append(names, proc.Name)
+	}
+	if want := []string{"web", "worker", "cron", "db"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected process types: %v, want %v", names, want)
+	}
+	if r.WorkDir != "app" || r.Include != nil {
+		t.Errorf("only the process types should be merged, workdir: %q, include: %v", r.WorkDir, r.Include)
+	}
+}
+
+func TestLoadIncludeConflicts(t *testing.T) {
+	dir := tempDir(t)
+	writeConfigs(t, dir, map[string]string{
+		"duplicate.json":    `{"procs": [{"name": "web", "cmd": ["./web"]}], "include": ["a.json", "b.json"]}`,
+		"a.json":            `{"procs": [{"name": "worker", "cmd": ["./worker"]}]}`,
+		"b.json":            `{"procs": [{"name": "worker", "cmd": ["./other-worker"]}]}`,
+		"cycle.json":        `{"include": ["cycle-nested.json"]}`,
+		"cycle-nested.json": `{"include": ["cycle.json"]}`,
+	})
+	var r Runner
+	err := r.Load(filepath.Join(dir, "duplicate.json"))
+	want := fmt.Sprintf(`%s: process type "worker" already declared in %s`, filepath.Join(dir, "b.json"), filepath.Join(dir, "a.json"))
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error: %v, want: %s", err, want)
+	}
+	var cycle Runner
+	if err := cycle.Load(filepath.Join(dir, "cycle.json")); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("include cycle not detected: %v", err)
+	}
+}
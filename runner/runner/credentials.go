@@ -0,0 +1,105 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// credential is the user and group a process type runs as.
+type credential struct {
+	uid, gid uint32
+}
+
+// lookupCredential resolves user and group names, or numeric ids, into a
+// credential. When only the user is given, its primary group is used. When
+// only the group is given, the user of the runner is kept.
+func lookupCredential(userName, groupName string) (credential, error) {
+	var cred credential
+	u, err := user.Current()
+	if userName != "" {
+		u, err = lookupUser(userName)
+	}
+	if err != nil {
+		return cred, fmt.Errorf("cannot find user: %v", err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return cred, fmt.Errorf("user %s has no numeric id: %v", u.Username, err)
+	}
+	gidStr := u.Gid
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return cred, fmt.Errorf("cannot find group: %v", err)
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return cred, fmt.Errorf("group %s has no numeric id: %v", gidStr, err)
+	}
+	cred.uid, cred.gid = uint32(uid), uint32(gid)
+	return cred, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		return &user.User{Uid: name, Gid: name, Username: name}, nil
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return &user.Group{Gid: name, Name: name}, nil
+	}
+	return user.LookupGroup(name)
+}
+
+// resolveCredentials looks up the users and groups of the process types procs,
+// so misconfigurations are reported before anything runs.
+func resolveCredentials(procs []*ProcessType) (map[string]credential, error) {
+	credentials := make(map[string]credential)
+	for _, proc := range procs {
+		if proc.User == "" {
+			continue
+		}
+		userName, groupName := proc.User, ""
+		if i := strings.Index(proc.User, ":"); i > -1 {
+			userName, groupName = proc.User[:i], proc.User[i+1:]
+		}
+		cred, err := lookupCredential(userName, groupName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", proc.Name, err)
+		}
+		credentials[proc.Name] = cred
+	}
+	return credentials, nil
+}
+
+// credential is the credential of the process type name, if it sets User.
+func (r *Runner) credential(name string) (credential, bool) {
+	r.procsMu.Lock()
+	defer r.procsMu.Unlock()
+	cred, ok := r.credentials[name]
+	return cred, ok
+}
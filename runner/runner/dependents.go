@@ -0,0 +1,68 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"log"
+	"os"
+)
+
+// stopDependents stops the running instances of the process types that set
+// StopOnDependencyFailure and wait for the instance procName, which stopped
+// for good. They are asked to terminate and, if still running after
+// ShutdownGracePeriod, killed.
+func (r *Runner) stopDependents(procName string) {
+	for _, sv := range r.processes() {
+		if !sv.StopOnDependencyFailure || !r.waitsFor(sv, procName) {
+			continue
+		}
+		r.liveMu.Lock()
+		for _, inst := range r.plan() {
+			if inst.proc != sv {
+				continue
+			}
+			p, ok := r.live[inst.name]
+			if !ok {
+				continue
+			}
+			log.Println("stopping", inst.name+",", "as", procName, "stopped")
+			terminateProcess(p)
+			go r.killAfterGracePeriod(inst.name, p)
+		}
+		r.liveMu.Unlock()
+	}
+}
+
+// waitsFor reports whether the WaitBefore or WaitFor of sv target the
+// instance procName.
+func (r *Runner) waitsFor(sv *ProcessType, procName string) bool {
+	for _, target := range []string{sv.WaitBefore, sv.WaitFor} {
+		if _, inst, ok := r.waitTargetInstance(target); ok && inst == procName {
+			return true
+		}
+	}
+	return false
+}
+
+// killAfterGracePeriod kills p, the activating command of the instance
+// procName, if it is still running once ShutdownGracePeriod is over.
+func (r *Runner) killAfterGracePeriod(procName string, p *os.Process) {
+	<-r.clock().After(r.ShutdownGracePeriod)
+	r.liveMu.Lock()
+	defer r.liveMu.Unlock()
+	if r.live[procName] == p {
+		killProcess(p)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadEnvFile reads an environment file, one VARIABLENAME=VALUE per line.
+// Malformed lines are ignored.
+func LoadEnvFile(fn string) ([]string, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.Split(strings.TrimSpace(scanner.Text()), "=")
+		if len(line) != 2 {
+			continue
+		}
+		env = append(env, scanner.Text())
+	}
+	return env, scanner.Err()
+}
+
+// SetBaseEnvironment replaces BaseEnvironment, and it is safe to call while
+// the runner is running. Processes started afterwards, including restarts,
+// see the new environment. Running processes keep the environment they were
+// started with.
+func (r *Runner) SetBaseEnvironment(env []string) {
+	r.baseEnvMu.Lock()
+	defer r.baseEnvMu.Unlock()
+	r.BaseEnvironment = append([]string(nil), env...)
+}
+
+func (r *Runner) baseEnvironment() []string {
+	r.baseEnvMu.Lock()
+	defer r.baseEnvMu.Unlock()
+	return append([]string(nil), r.BaseEnvironment...)
+}
+
+// mergeEnv removes the repeated variables of env, the latter occurrences
+// taking precedence over the former ones. Each variable keeps the position of
+// its first occurrence.
+func mergeEnv(env []string) []string {
+	merged := make([]string, 0, len(env))
+	pos := make(map[string]int, len(env))
+	for _, kv := range env {
+		key := kv
+		if i := strings.Index(kv, "="); i > 0 {
+			key = kv[:i]
+		}
+		if i, ok := pos[key]; ok {
+			merged[i] = kv
+			continue
+		}
+		pos[key] = len(merged)
+		merged = append(merged, kv)
+	}
+	return merged
+}
+
+func (r *Runner) loadProcessEnvFiles(sv *ProcessType) ([]string, error) {
+	var env []string
+	for _, fn := range sv.EnvFiles {
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(r.WorkDir, fn)
+		}
+		vars, err := LoadEnvFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, vars...)
+	}
+	return env, nil
+}
+
+// openStdinFile opens the file fed to the standard input of the commands of
+// the process type.
+func (r *Runner) openStdinFile(sv *ProcessType) (*os.File, error) {
+	fn := sv.StdinFile
+	if !filepath.IsAbs(fn) {
+		fn = filepath.Join(r.WorkDir, fn)
+	}
+	return os.Open(fn)
+}
+
+// prependPath returns the PATH variable of env with dirs in front of it.
+func (r *Runner) prependPath(env []string, dirs []string) string {
+	var path string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = strings.TrimPrefix(kv, "PATH=")
+		}
+	}
+	var paths []string
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(r.WorkDir, dir)
+		}
+		paths = append(paths, dir)
+	}
+	if path != "" {
+		paths = append(paths, path)
+	}
+	return "PATH=" + strings.Join(paths, string(os.PathListSeparator))
+}
+
+// expandEnv replaces the variables in s, as described in expandVars, with the
+// values of the runner environment overlaid with the base environment, as
+// given to the processes.
+func (r *Runner) expandEnv(s string) (string, error) {
+	env := append(os.Environ(), r.baseEnvironment()...)
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	return expandVars(s, func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})
+}
+
+// labelsEnv are the environment variables of the runner Labels, sorted.
+func (r *Runner) labelsEnv() []string {
+	env := make([]string, 0, len(r.Labels))
+	for name, value := range r.Labels {
+		env = append(env, labelEnvVar(name)+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+func labelEnvVar(name string) string {
+	return normalizeByEnvVarRules("RUNNER_LABEL_" + name)
+}
+
+func isBuild(sv *ProcessType) bool {
+	return strings.HasPrefix(sv.Name, "build")
+}
+
+// prepareBuildEnvOut creates the file that a build process type can use to
+// export environment variables to the non-build process types. Its path is
+// given to the build through the environment variable "RUNNER_ENV_OUT".
+func (r *Runner) prepareBuildEnvOut(sv *ProcessType) (string, error) {
+	if !isBuild(sv) {
+		return "", nil
+	}
+	f, err := ioutil.TempFile("", "runner-env-out")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func (r *Runner) storeBuildEnv(sv *ProcessType, envOut string) error {
+	env, err := LoadEnvFile(envOut)
+	if err != nil {
+		return err
+	}
+	r.buildEnvMu.Lock()
+	defer r.buildEnvMu.Unlock()
+	if r.buildEnv == nil {
+		r.buildEnv = make(map[string][]string)
+	}
+	r.buildEnv[sv.Name] = env
+	return nil
+}
+
+// collectBuildEnv assembles the environment exported by all builds, in their
+// order of declaration.
+func (r *Runner) collectBuildEnv() {
+	r.buildEnvMu.Lock()
+	defer r.buildEnvMu.Unlock()
+	var env []string
+	for _, sv := range r.processes() {
+		env = append(env, r.buildEnv[sv.Name]...)
+	}
+	r.buildEnvOutput = env
+}
+
+func (r *Runner) buildExportedEnv() []string {
+	r.buildEnvMu.Lock()
+	defer r.buildEnvMu.Unlock()
+	return r.buildEnvOutput
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// expandVars replaces in s the variables $VAR and ${VAR} with their values,
+// as given by lookup, along with the following subset of the shell parameter
+// expansions:
+//
+//	${VAR:-default}  default if VAR is unset or empty
+//	${VAR-default}   default if VAR is unset
+//	${VAR:?message}  fails with message if VAR is unset or empty
+//	${VAR?message}   fails with message if VAR is unset
+//
+// Defaults are expanded too. Unset variables expand to empty.
+func expandVars(s string, lookup func(string) (string, bool)) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '{' {
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("missing closing brace in %q", s[i:])
+			}
+			v, err := expandParameter(s[i+2:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			i = end
+			continue
+		}
+		name := varName(s[i+1:])
+		if name == "" {
+			buf.WriteByte(s[i])
+			continue
+		}
+		v, _ := lookup(name)
+		buf.WriteString(v)
+		i += len(name)
+	}
+	return buf.String(), nil
+}
+
+// expandParameter expands the contents of a ${...} expression.
+func expandParameter(expr string, lookup func(string) (string, bool)) (string, error) {
+	name := varName(expr)
+	if name == "" {
+		return "", fmt.Errorf("bad substitution ${%s}", expr)
+	}
+	v, set := lookup(name)
+	op := expr[len(name):]
+	if op == "" {
+		return v, nil
+	}
+	checkEmpty := strings.HasPrefix(op, ":")
+	if checkEmpty {
+		op = op[1:]
+	}
+	missing := !set || checkEmpty && v == ""
+	if op == "" {
+		return "", fmt.Errorf("bad substitution ${%s}", expr)
+	}
+	switch word := op[1:]; op[0] {
+	case '-':
+		if missing {
+			return expandVars(word, lookup)
+		}
+		return v, nil
+	case '?':
+		if !missing {
+			return v, nil
+		}
+		if word == "" {
+			word = "parameter not set"
+			if checkEmpty {
+				word = "parameter null or not set"
+			}
+		}
+		return "", fmt.Errorf("%s: %s", name, word)
+	default:
+		return "", fmt.Errorf("bad substitution ${%s}", expr)
+	}
+}
+
+// closingBrace finds the brace that closes the expression starting at
+// s[start], taking nested expressions into account. It returns -1 if there is
+// none.
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// varName is the variable name at the beginning of s.
+func varName(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustExpandEnv(t *testing.T, r *Runner, s string) string {
+	t.Helper()
+	v, err := r.expandEnv(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestExpandVars(t *testing.T) {
+	env := map[string]string{"HOST": "db.example.com", "PORT": "5432", "EMPTY": ""}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"$HOST:$PORT", "db.example.com:5432"},
+		{"${HOST}:${PORT}", "db.example.com:5432"},
+		{"${HOST:-localhost}:${PORT}", "db.example.com:5432"},
+		{"${MISSING:-localhost}:${PORT}", "localhost:5432"},
+		{"${EMPTY:-localhost}", "localhost"},
+		{"${EMPTY-localhost}", ""},
+		{"${MISSING-localhost}", "localhost"},
+		{"${MISSING:-$HOST}", "db.example.com"},
+		{"${MISSING:-${OTHER:-fallback}}/x", "fallback/x"},
+		{"${HOST:?host is required}", "db.example.com"},
+		{"$MISSING/x", "/x"},
+		{"cost: 5$", "cost: 5$"},
+		{"$ $1", "$ $1"},
+	} {
+		got, err := expandVars(tt.in, lookup)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandVarsErrors(t *testing.T) {
+	env := map[string]string{"EMPTY": ""}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	for in, want := range map[string]string{
+		"${MISSING:?set MISSING to the database address}": "MISSING: set MISSING to the database address",
+		"${EMPTY:?}":    "EMPTY: parameter null or not set",
+		"${MISSING?}":   "MISSING: parameter not set",
+		"${MISSING":     `missing closing brace in "${MISSING"`,
+		"${MISSING:+x}": "bad substitution ${MISSING:+x}",
+		"${}":           "bad substitution ${}",
+	} {
+		_, err := expandVars(in, lookup)
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: unexpected error: %v, want: %s", in, err, want)
+		}
+	}
+	if got, err := expandVars("${EMPTY?unset}", lookup); err != nil || got != "" {
+		t.Errorf("set but empty variables should pass ${VAR?}: %q, %v", got, err)
+	}
+}
+
+func TestExpandEnvDefaults(t *testing.T) {
+	r := New()
+	r.SetBaseEnvironment([]string{"DB_HOST=db.example.com"})
+	if got := mustExpandEnv(t, &r, "${DB_HOST:-localhost}:${DB_PORT:-5432}"); got != "db.example.com:5432" {
+		t.Errorf("unexpected expansion: %q", got)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`touch "$PS"`}, WaitFor: "${DB_ADDR:?DB_ADDR must be set}"},
+	}
+	var out syncBuffer
+	r.MetaOutput = &out
+	stop := startRunner(t, &r)
+	defer stop()
+	if !eventually(t, func() bool {
+		return strings.Contains(out.String(), "cannot expand wait target: DB_ADDR: DB_ADDR must be set")
+	}) {
+		t.Error("missing error about the unset variable:", out.String())
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// forwardedSignals parses ForwardSignals. SIGINT and SIGTERM are refused, as
+// they stop the runner.
+func (r *Runner) forwardedSignals() ([]os.Signal, error) {
+	var sigs []os.Signal
+	for _, name := range r.ForwardSignals {
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+			return nil, fmt.Errorf("%v cannot be forwarded, it stops the runner", name)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// forwardSignals relays the ForwardSignals received by the runner to the
+// running process instances, until ctx is cancelled.
+func (r *Runner) forwardSignals(ctx context.Context) error {
+	sigs, err := r.forwardedSignals()
+	if err != nil || len(sigs) == 0 {
+		return err
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				r.liveMu.Lock()
+				for name, p := range r.live {
+					if err := signalProcess(p, sig.(syscall.Signal)); err != nil {
+						fmt.Fprintln(r.metaOutput(), "cannot forward", sig, "to", name+":", err)
+					}
+				}
+				r.liveMu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
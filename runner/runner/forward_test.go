@@ -0,0 +1,119 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestForwardSignals(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.ForwardSignals = []string{"USR1"}
+	r.Processes = []*ProcessType{
+		{
+			Name: "web",
+			Cmd: []string{
+				`trap 'echo usr1 >> "$PS.signals"' USR1; touch "$PS.started"; while true; do sleep 0.05; done`,
+			},
+		},
+	}
+	r.Formation["web"] = 2
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool {
+		return fileExists(filepath.Join(r.WorkDir, "web.0.started")) &&
+			fileExists(filepath.Join(r.WorkDir, "web.1.started"))
+	}) {
+		t.Fatal("web instances did not start")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"web.0", "web.1"} {
+		fn := filepath.Join(r.WorkDir, name+".signals")
+		if !eventually(t, func() bool {
+			b, _ := ioutil.ReadFile(fn)
+			return strings.Contains(string(b), "usr1")
+		}) {
+			t.Error("SIGUSR1 not forwarded to", name)
+		}
+	}
+}
+
+func TestForwardSignalsScript(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.ForwardSignals = []string{"USR1"}
+	server := filepath.Join(r.WorkDir, "server.sh")
+	script := "#!/bin/sh\n" +
+		`echo $$ > "$PS.pid"` + "\n" +
+		`trap 'echo $$ >> "$PS.signals"' USR1` + "\n" +
+		"while true; do sleep 0.05; done\n"
+	if err := ioutil.WriteFile(server, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{{Name: "web", Cmd: []string{server}}}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	pidFn := filepath.Join(r.WorkDir, "web.0.pid")
+	var pid []byte
+	if !eventually(t, func() bool {
+		pid, _ = ioutil.ReadFile(pidFn)
+		return len(pid) > 0
+	}) {
+		t.Fatal("web.0 did not start")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	signalsFn := filepath.Join(r.WorkDir, "web.0.signals")
+	var signals []byte
+	if !eventually(t, func() bool {
+		signals, _ = ioutil.ReadFile(signalsFn)
+		return len(signals) > 0
+	}) {
+		t.Fatal("SIGUSR1 not forwarded to the script")
+	}
+	if string(signals) != string(pid) {
+		t.Errorf("SIGUSR1 forwarded to the wrong process. got: %s, want: %s", signals, pid)
+	}
+	for _, st := range r.Status() {
+		if st.Name == "web.0" && (!st.Running || st.Starts != 1) {
+			t.Errorf("web.0 should still be running its first start: %+v", st)
+		}
+	}
+}
+
+func TestForwardSignalsValidation(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{{Name: "web", Cmd: []string{"true"}}}
+	for _, sig := range []string{"SIGTERM", "INT", "BOGUS"} {
+		r.ForwardSignals = []string{sig}
+		if err := r.Validate(); err == nil {
+			t.Error("expected error missing for", sig)
+		}
+	}
+}
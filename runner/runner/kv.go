@@ -0,0 +1,95 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Bounds of the key/value store served along with the service discovery.
+const (
+	maxKVKeySize   = 256
+	maxKVValueSize = 64 << 10
+	maxKVEntries   = 1024
+)
+
+// kvStore is the in-memory key/value store that the processes can use to share
+// small pieces of runtime state, on the path /kv/{key} of the service
+// discovery: GET reads a value, PUT writes it.
+type kvStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func (kv *kvStore) get(key string) ([]byte, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	v, ok := kv.entries[key]
+	return v, ok
+}
+
+func (kv *kvStore) put(key string, value []byte) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.entries == nil {
+		kv.entries = make(map[string][]byte)
+	}
+	if _, ok := kv.entries[key]; !ok && len(kv.entries) >= maxKVEntries {
+		return fmt.Errorf("key/value store is full (%d entries)", maxKVEntries)
+	}
+	kv.entries[key] = value
+	return nil
+}
+
+func (r *Runner) serveKV(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/kv/")
+	switch {
+	case key == "":
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	case len(key) > maxKVKeySize:
+		http.Error(w, fmt.Sprintf("key longer than %d bytes", maxKVKeySize), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		v, ok := r.kv.get(key)
+		if !ok {
+			http.Error(w, "key not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(v)
+	case http.MethodPut:
+		v, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxKVValueSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("value longer than %d bytes", maxKVValueSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := r.kv.put(key, v); err != nil {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
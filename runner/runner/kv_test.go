@@ -0,0 +1,99 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestKV(t *testing.T) {
+	r := New()
+	r.ServiceDiscoveryAddr = "localhost:0"
+	r.Processes = []*ProcessType{
+		{Name: "leader", Cmd: []string{`curl -sf -X PUT --data-binary "token-$PS" http://$DISCOVERY/kv/leader; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	var addr string
+	eventually(t, func() bool {
+		r.sdMu.Lock()
+		defer r.sdMu.Unlock()
+		addr = r.ServiceDiscoveryAddr
+		return !strings.HasSuffix(addr, ":0")
+	})
+	do := func(method, key, value string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://"+addr+"/kv/"+key, strings.NewReader(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := do(http.MethodGet, "missing", ""); code != http.StatusNotFound {
+		t.Error("unexpected status for a missing key:", code)
+	}
+	if code, _ := do(http.MethodPut, "secret", "s3cr3t"); code != http.StatusNoContent {
+		t.Error("unexpected status writing a value:", code)
+	}
+	if code, body := do(http.MethodGet, "secret", ""); code != http.StatusOK || body != "s3cr3t" {
+		t.Errorf("unexpected value read. status: %d, value: %q", code, body)
+	}
+	if code, _ := do(http.MethodPut, "big", strings.Repeat("x", maxKVValueSize+1)); code != http.StatusRequestEntityTooLarge {
+		t.Error("unexpected status writing an oversized value:", code)
+	}
+	if code, _ := do(http.MethodPut, strings.Repeat("k", maxKVKeySize+1), "v"); code != http.StatusBadRequest {
+		t.Error("unexpected status writing an oversized key:", code)
+	}
+	if code, _ := do(http.MethodDelete, "secret", ""); code != http.StatusMethodNotAllowed {
+		t.Error("unexpected status for an unsupported method:", code)
+	}
+
+	if _, err := exec.LookPath("curl"); err == nil {
+		if !eventually(t, func() bool {
+			code, body := do(http.MethodGet, "leader", "")
+			return code == http.StatusOK && body == "token-leader.0"
+		}) {
+			t.Error("value written by the process not found")
+		}
+	}
+}
+
+func TestKVFull(t *testing.T) {
+	var kv kvStore
+	for i := 0; i < maxKVEntries; i++ {
+		if err := kv.put(fmt.Sprint("key", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.put("one-too-many", nil); err == nil {
+		t.Error("expected error missing")
+	}
+	if err := kv.put("key0", []byte("overwritten")); err != nil {
+		t.Error("existing keys should still be writable:", err)
+	}
+}
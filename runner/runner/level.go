@@ -0,0 +1,52 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultLevelPattern is a LevelPattern for the common level prefixes, like
+// "ERROR", "[warn]" or "level=info".
+const DefaultLevelPattern = `(?i)^\W*(?:level=)?(trace|debug|info|warn(?:ing)?|error|fatal|panic)\b`
+
+// parseLevel extracts the severity level of the line with re, lowercased.
+// It is empty if re is nil or does not match.
+func parseLevel(re *regexp.Regexp, line string) string {
+	if re == nil {
+		return ""
+	}
+	m := re.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return strings.ToLower(m[1])
+	default:
+		return strings.ToLower(m[0])
+	}
+}
+
+// colorizeLevel highlights the lines of warning level and above.
+func colorizeLevel(level, line string) string {
+	switch {
+	case strings.HasPrefix(level, "warn"):
+		return "\x1b[33m" + line + colorReset // yellow
+	case strings.HasPrefix(level, "err"), level == "fatal", level == "panic", strings.HasPrefix(level, "crit"):
+		return "\x1b[31m" + line + colorReset // red
+	}
+	return line
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		pattern string
+		line    string
+		want    string
+	}{
+		{DefaultLevelPattern, "ERROR cannot connect", "error"},
+		{DefaultLevelPattern, "[warn] slow query", "warn"},
+		{DefaultLevelPattern, "level=info msg=started", "info"},
+		{DefaultLevelPattern, "WARNING: disk almost full", "warning"},
+		{DefaultLevelPattern, "listening on :8080", ""},
+		{DefaultLevelPattern, "informative message", ""},
+		{`^\d{2}:\d{2} (\w+)`, "12:01 DEBUG tick", "debug"},
+		{`^[EWI]\b`, "E connection lost", "e"},
+	}
+	for _, tt := range tests {
+		if got := parseLevel(regexp.MustCompile(tt.pattern), tt.line); got != tt.want {
+			t.Errorf("parseLevel(%q, %q) = %q, want: %q", tt.pattern, tt.line, got, tt.want)
+		}
+	}
+	if got := parseLevel(nil, "ERROR cannot connect"); got != "" {
+		t.Error("lines should have no level without a pattern:", got)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	r := New()
+	r.ServiceDiscoveryAddr = "localhost:0"
+	r.LevelPattern = DefaultLevelPattern
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"while true; do echo 'ERROR failed'; echo '[warn] slow'; echo 'INFO ok'; echo plain; sleep 0.05; done"}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	var addr string
+	eventually(t, func() bool {
+		r.sdMu.Lock()
+		defer r.sdMu.Unlock()
+		_, ok := r.dynamicServiceDiscovery["WEB_0_PORT"]
+		addr = r.ServiceDiscoveryAddr
+		return ok
+	})
+	resp, err := http.Get("http://" + addr + "/logs?proc=web&format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	want := map[string]string{"ERROR failed": "error", "[warn] slow": "warn", "INFO ok": "info", "plain": ""}
+	got := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for len(got) < len(want) && scanner.Scan() {
+		var l jsonLogLine
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		if l.Name != "web.0" {
+			t.Error("unexpected process name:", l.Name)
+		}
+		got[l.Text] = l.Level
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for text, level := range want {
+		if got[text] != level {
+			t.Errorf("%q: unexpected level %q, want: %q", text, got[text], level)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits are the resource limits applied to each command of a process type,
+// and inherited by the processes they spawn. They are set by the shell that
+// runs the command (through ulimit) right before executing it, so they are
+// subject to what the platform enforces: RLIMIT_AS is enforced on Linux and on
+// the BSDs, but not on macOS; neither is available on Windows.
+type Limits struct {
+	// Memory is the maximum size in bytes of the virtual memory of each
+	// process (RLIMIT_AS). It is rounded down to KiB. Processes that
+	// exceed it fail to allocate memory, which usually make them crash.
+	Memory int64 `json:"memory,omitempty"`
+
+	// CPU is the maximum CPU time of each process (RLIMIT_CPU). It is
+	// rounded up to seconds. Processes that exceed it are killed.
+	CPU time.Duration `json:"cpu,omitempty"`
+}
+
+func (l *Limits) validate() error {
+	switch {
+	case l.Memory < 0:
+		return fmt.Errorf("invalid memory limit: %d", l.Memory)
+	case l.Memory > 0 && l.Memory < 1024:
+		return fmt.Errorf("memory limit too small: %d", l.Memory)
+	case l.CPU < 0:
+		return fmt.Errorf("invalid CPU limit: %v", l.CPU)
+	}
+	return nil
+}
+
+// limitCommand prefixes cmd with the ulimit calls that enforce l.
+func limitCommand(l *Limits, cmd string) string {
+	if l == nil {
+		return cmd
+	}
+	var ulimits []string
+	if l.Memory > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", l.Memory/1024))
+	}
+	if l.CPU > 0 {
+		secs := (l.CPU + time.Second - 1) / time.Second
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", secs))
+	}
+	if len(ulimits) == 0 {
+		return cmd
+	}
+	return strings.Join(ulimits, " && ") + " && " + cmd
+}
+
+func validUmask(mask int) bool {
+	return mask >= 0 && mask <= 0777
+}
+
+// umaskCommand prefixes cmd with the umask call that sets mask, if any.
+func umaskCommand(mask *int, cmd string) string {
+	if mask == nil {
+		return cmd
+	}
+	return fmt.Sprintf("umask %04o && %s", *mask, cmd)
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{
+			Name:   "hog",
+			Cmd:    []string{`awk 'BEGIN { s = "x"; while (length(s) < 268435456) s = s s }'`},
+			Limits: &Limits{Memory: 32 << 20},
+		},
+		{
+			Name:   "spin",
+			Cmd:    []string{"while :; do :; done"},
+			Limits: &Limits{CPU: time.Second},
+		},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	lastExit := func(name string) (int, bool) {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		st, ok := r.stats[name]
+		if !ok || !st.startedAt.IsZero() {
+			return 0, false
+		}
+		return st.lastExitCode, true
+	}
+	for _, name := range []string{"hog.0", "spin.0"} {
+		var code int
+		ok := eventually(t, func() bool {
+			var exited bool
+			code, exited = lastExit(name)
+			return exited
+		})
+		if !ok {
+			t.Errorf("%s should have been stopped by its limits", name)
+		} else if code == 0 {
+			t.Errorf("%s should have failed when exceeding its limits", name)
+		}
+	}
+}
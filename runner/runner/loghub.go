@@ -0,0 +1,169 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// logLine is a line of output of a process type instance.
+type logLine struct {
+	name  string // process name (e.g. "web.0")
+	level string // see Runner.LevelPattern
+	text  string
+}
+
+// logHub fans out the output of all processes to its subscribers. Slow
+// subscribers miss lines, they never block the processes.
+type logHub struct {
+	mu          sync.Mutex
+	subscribers map[chan logLine]struct{}
+}
+
+func (h *logHub) subscribe() chan logLine {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan logLine]struct{})
+	}
+	ch := make(chan logLine, 1024)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *logHub) unsubscribe(ch chan logLine) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+func (h *logHub) publish(name, level, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- logLine{name, level, text}:
+		default:
+		}
+	}
+}
+
+// OutputReader streams the output of the processes, one line at a time
+// prefixed with the process name (e.g. "web.0: listening"), from the moment it
+// is called until it is closed. Each reader gets the full stream, buffered up
+// to 1024 lines: a reader that falls behind misses lines, it never blocks the
+// processes. Read returns io.EOF once the reader is closed.
+func (r *Runner) OutputReader() io.ReadCloser {
+	return &outputReader{
+		hub:   &r.logs,
+		lines: r.logs.subscribe(),
+		done:  make(chan struct{}),
+	}
+}
+
+type outputReader struct {
+	hub   *logHub
+	lines chan logLine
+	buf   []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (o *outputReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		select {
+		case <-o.done:
+			return 0, io.EOF
+		case l := <-o.lines:
+			o.buf = []byte(l.name + ": " + l.text + "\n")
+		}
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+func (o *outputReader) Close() error {
+	o.closeOnce.Do(func() {
+		o.hub.unsubscribe(o.lines)
+		close(o.done)
+	})
+	return nil
+}
+
+// matchProcName reports whether the process name belongs to the filter, which
+// is either a process type name (e.g. "web") or a process name (e.g. "web.0").
+// Empty filters match everything.
+func matchProcName(filter, name string) bool {
+	return filter == "" || name == filter || strings.HasPrefix(name, filter+".")
+}
+
+// jsonLogLine is a line of the JSON stream of serveLogs.
+type jsonLogLine struct {
+	Name  string `json:"name"`
+	Level string `json:"level,omitempty"`
+	Text  string `json:"text"`
+}
+
+// serveLogs streams the output of the processes until the client disconnects.
+// The query parameter "proc" filters the output of a single process type or
+// process. With the query parameter "format=json", each line is streamed as a
+// JSON object, along with its level.
+func (r *Runner) serveLogs(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	filter := req.URL.Query().Get("proc")
+	asJSON := req.URL.Query().Get("format") == "json"
+	lines := r.logs.subscribe()
+	defer r.logs.unsubscribe(lines)
+
+	if asJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher.Flush()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case l := <-lines:
+			if !matchProcName(filter, l.name) {
+				continue
+			}
+			var err error
+			if asJSON {
+				err = enc.Encode(jsonLogLine{Name: l.name, Level: l.level, Text: l.text})
+			} else {
+				_, err = fmt.Fprintf(w, "%s: %s\n", l.name, l.text)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAttachLogs(t *testing.T) {
+	r := New()
+	r.ServiceDiscoveryAddr = "localhost:0"
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"while true; do echo tick; sleep 0.05; done"}},
+		{Name: "worker", Cmd: []string{"while true; do echo tock; sleep 0.05; done"}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	attach := func() {
+		t.Helper()
+		var addr string
+		eventually(t, func() bool {
+			r.sdMu.Lock()
+			defer r.sdMu.Unlock()
+			_, ok := r.dynamicServiceDiscovery["WEB_0_PORT"]
+			addr = r.ServiceDiscoveryAddr
+			return ok
+		})
+		resp, err := http.Get("http://" + addr + "/logs?proc=web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for i := 0; i < 3 && scanner.Scan(); i++ {
+			if line := scanner.Text(); !strings.HasPrefix(line, "web.0: ") {
+				t.Error("unexpected line:", line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attach()
+	attach()
+
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if st := r.stats["web.0"]; st.starts != 1 || st.startedAt.IsZero() {
+		t.Errorf("detaching should not affect the process: %+v", st)
+	}
+}
+
+func TestOutputReader(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"while true; do echo tick; sleep 0.05; done"}},
+	}
+	readers := []io.ReadCloser{r.OutputReader(), r.OutputReader()}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for _, rdr := range readers {
+		scanner := bufio.NewScanner(rdr)
+		ticks := 0
+		for ticks < 3 && scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "web.0: ") {
+				t.Error("unexpected line:", line)
+			}
+			if line == "web.0: tick" {
+				ticks++
+			}
+		}
+		if ticks < 3 {
+			t.Error("missing lines, error:", scanner.Err())
+		}
+		rdr.Close()
+		if _, err := ioutil.ReadAll(rdr); err != nil {
+			t.Error("closed readers should reach EOF:", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func (r *Runner) output() io.Writer {
+	if r.Output == nil {
+		return os.Stdout
+	}
+	return r.Output
+}
+
+func (r *Runner) metaOutput() io.Writer {
+	if r.MetaOutput == nil {
+		return r.output()
+	}
+	return r.MetaOutput
+}
+
+// prefixWidth is the width of the column of process names that prefixes each
+// line of output. It fits every name actually printed: build process types are
+// printed by their names, the others by their instance names (e.g. "web.11"),
+// with their labels in place of the names when set. It is computed from the
+// current configuration, so it follows process types added after the start.
+//
+// It is called for each reader of output, so it is derived from the process
+// types and their formations rather than from each instance.
+func (r *Runner) prefixWidth() int {
+	width := 0
+	for _, proc := range r.processes() {
+		w := len(outputLabel(proc, -1))
+		if !isBuild(proc) {
+			count := r.formationCount(proc)
+			if count < 1 {
+				continue
+			}
+			w += len(".") + len(strconv.Itoa(count-1))
+		}
+		if w > width {
+			width = w
+		}
+	}
+	return width + 1
+}
+
+// scanBuffers are the initial buffers of the readers of output, reused across
+// the runs of the processes. The scanners grow them as needed for longer
+// lines, up to maxLineLength.
+var scanBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 4096)
+		return &buf
+	},
+}
+
+// maxLineLength is the longest line of output that can be read.
+const maxLineLength = 2 * 1048576
+
+// outputLabel is how the output of an instance of sv is prefixed, or of sv
+// itself if instance is -1. See ProcessType.Label.
+func outputLabel(sv *ProcessType, instance int) string {
+	label := sv.Name
+	if sv.Label != "" {
+		label = sv.Label
+	}
+	if instance > -1 {
+		label = fmt.Sprintf("%v.%v", label, instance)
+	}
+	return label
+}
+
+// lineWriter serializes the lines printed by the concurrent readers of the
+// processes' output, so each line reaches the output in a single write and
+// never interleaves with another.
+type lineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+
+	// buffers, when not nil, hold the lines written to each output until
+	// they are flushed. See Runner.LogFlushInterval.
+	buffers map[io.Writer]*bufio.Writer
+}
+
+func (lw *lineWriter) writeLine(w io.Writer, parts ...string) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.buf = lw.buf[:0]
+	for i, p := range parts {
+		if i > 0 {
+			lw.buf = append(lw.buf, ' ')
+		}
+		lw.buf = append(lw.buf, p...)
+	}
+	lw.buf = append(lw.buf, '\n')
+	if lw.buffers != nil {
+		bw, ok := lw.buffers[w]
+		if !ok {
+			bw = bufio.NewWriter(w)
+			lw.buffers[w] = bw
+		}
+		_, err := bw.Write(lw.buf)
+		return err
+	}
+	_, err := w.Write(lw.buf)
+	return err
+}
+
+// flushEvery buffers the lines written from now on, flushing them every
+// interval, until stopBuffering is called or ctx is cancelled.
+func (lw *lineWriter) flushEvery(ctx context.Context, c clock, interval time.Duration) {
+	lw.mu.Lock()
+	lw.buffers = make(map[io.Writer]*bufio.Writer)
+	lw.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.After(interval):
+				lw.mu.Lock()
+				lw.flush()
+				lw.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopBuffering flushes the buffered lines, and writes the next ones right
+// away.
+func (lw *lineWriter) stopBuffering() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.flush()
+	lw.buffers = nil
+}
+
+func (lw *lineWriter) flush() {
+	for _, bw := range lw.buffers {
+		bw.Flush()
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutputLinesAreNotSplit(t *testing.T) {
+	const (
+		procs   = 4
+		count   = 4
+		lines   = 50
+		payload = 5000
+	)
+	pr, pw := io.Pipe()
+	var total int64
+	badc := make(chan []string)
+	go func() {
+		var bad []string
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 65536), 1048576)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.Contains(line, "xxx") {
+				continue
+			}
+			f := strings.Fields(line)
+			if len(f) != 4 || f[0] != f[2] || f[1] != ":" || len(f[3]) != payload {
+				bad = append(bad, line)
+				continue
+			}
+			atomic.AddInt64(&total, 1)
+		}
+		badc <- bad
+	}()
+
+	r := New()
+	r.Output = pw
+	for i := 0; i < procs; i++ {
+		name := fmt.Sprint("chatty", i)
+		r.Processes = append(r.Processes, &ProcessType{
+			Name: name,
+			Cmd: []string{fmt.Sprintf(
+				`awk 'BEGIN { s = "x"; while (length(s) < %d) s = s s; s = substr(s, 1, %[1]d); for (i = 0; i < %d; i++) print ENVIRON["PS"], s }'; exec sleep 30`,
+				payload, lines)},
+		})
+		r.Formation[name] = count
+	}
+	const want = procs * count * lines
+	stop := startRunner(t, &r)
+	eventually(t, func() bool { return atomic.LoadInt64(&total) >= want })
+	stop()
+	pw.Close()
+	bad := <-badc
+	for _, line := range bad {
+		if len(line) > 80 {
+			line = line[:80] + "..."
+		}
+		t.Error("split line:", line)
+	}
+	if got := atomic.LoadInt64(&total); got != want {
+		t.Errorf("unexpected number of lines: got %d, want %d", got, want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestOutput(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.Output = &buf
+	r.Summary = true
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"echo hello from $PS; exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return strings.Contains(buf.String(), "hello from web.0") })
+	stop()
+	out := buf.String()
+	t.Log(out)
+	if !ok {
+		t.Fatal("process output not written to Output")
+	}
+	for _, want := range []string{"web.0 : hello from web.0", `web.0 : running "echo hello`, "summary:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q from the output", want)
+		}
+	}
+}
+
+func TestMetaOutput(t *testing.T) {
+	var out, meta syncBuffer
+	r := New()
+	r.Output = &out
+	r.MetaOutput = &meta
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"echo hello from $PS; exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return strings.Contains(out.String(), "hello from web.0") })
+	stop()
+	if !ok {
+		t.Fatal("process output not written to Output")
+	}
+	if strings.Contains(out.String(), "running") {
+		t.Errorf("meta messages should not be written to Output: %q", out.String())
+	}
+	if !strings.Contains(meta.String(), `web.0 : running "echo hello`) {
+		t.Errorf("meta messages missing from MetaOutput: %q", meta.String())
+	}
+	if strings.Contains(meta.String(), "hello from web.0") {
+		t.Errorf("process output should not be written to MetaOutput: %q", meta.String())
+	}
+}
+
+func TestOutputAlignment(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.Output = &buf
+	r.Processes = []*ProcessType{
+		{Name: "build-assets-bundle", Cmd: []string{"echo built"}},
+		{Name: "web", Cmd: []string{"echo up; exec sleep 30"}},
+		{Name: "worker", Cmd: []string{"echo up; exec sleep 30"}},
+	}
+	r.Formation["web"] = 12
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return strings.Count(buf.String(), ": up") == 13 })
+	stop()
+	if !ok {
+		t.Fatal("processes did not start:", buf.String())
+	}
+
+	columns := make(map[int][]string)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		columns[strings.Index(line, ":")] = append(columns[strings.Index(line, ":")], line)
+	}
+	if len(columns) != 1 {
+		t.Errorf("lines are not aligned: %v", columns)
+	}
+	for col := range columns {
+		if want := len("build-assets-bundle") + 1; col != want {
+			t.Errorf("unexpected prefix width. got: %d, want: %d", col, want)
+		}
+	}
+}
+
+func TestOutputLabel(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.Output = &buf
+	r.MetaOutput = ioutil.Discard
+	r.Processes = []*ProcessType{
+		{Name: "build-background-image-resizer", Label: "build-resizer", Cmd: []string{"echo built"}},
+		{Name: "background-image-resizer", Label: "resizer", Cmd: []string{"echo up; exec sleep 30"}},
+		{Name: "web", Cmd: []string{"echo up; exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return strings.Count(buf.String(), ": up") == 2 })
+	stop()
+	if !ok {
+		t.Fatal("processes did not start:", buf.String())
+	}
+
+	out := buf.String()
+	for _, want := range []string{"build-resizer : built", "resizer.0     : up", "web.0         : up"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in the output: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "background-image-resizer") {
+		t.Error("the labels should replace the names in the output:", out)
+	}
+}
+
+func TestValidateOutputLabel(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"./web"}},
+		{Name: "web-canary", Label: "web", Cmd: []string{"./web"}},
+	}
+	const want = `web and web-canary are both labeled "web" in the output`
+	if err := r.Validate(); err == nil || err.Error() != want {
+		t.Errorf("unexpected error: %v, want: %s", err, want)
+	}
+}
+
+func TestBuildSteps(t *testing.T) {
+	var meta syncBuffer
+	tracer := &recordingTracer{}
+	r := New()
+	r.MetaOutput = &meta
+	r.Output = ioutil.Discard
+	r.Tracer = tracer
+	r.Processes = []*ProcessType{
+		{Name: "build-assets", Cmd: []string{"true", "echo bundling", "true"}},
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return strings.Contains(meta.String(), "web.0") })
+	stop()
+	if !ok {
+		t.Fatal("web did not start")
+	}
+	out := meta.String()
+	for _, want := range []string{
+		`build-assets : [1/3] running "true"`,
+		`build-assets : [2/3] running "echo bundling"`,
+		`build-assets : [3/3] running "true"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing step marker %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `web.0 : [1/1]`) {
+		t.Error("step markers apply only to builds with many commands")
+	}
+	step, ok := tracer.find(`step [2/3] echo bundling`)
+	if !ok || !step.ended || step.parent == nil || step.parent.name != "process build-assets" {
+		t.Errorf("unexpected step span: %+v", step)
+	}
+}
+
+func TestNoBanner(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.Output = &buf
+	r.Processes = []*ProcessType{
+		{Name: "quiet", Cmd: []string{"echo hello from $PS; exec sleep 30"}, NoBanner: true},
+		{Name: "loud", Cmd: []string{"echo hello from $PS; exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool {
+		return strings.Contains(buf.String(), "hello from quiet.0") && strings.Contains(buf.String(), "hello from loud.0")
+	})
+	stop()
+	out := buf.String()
+	if !ok {
+		t.Fatal("processes did not start:", out)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "quiet.0") {
+			continue
+		}
+		if strings.Contains(line, "running") || strings.Contains(line, "listening on") || strings.TrimSpace(line) == "quiet.0 :" {
+			t.Errorf("unexpected banner line for quiet.0: %q", line)
+		}
+	}
+	if !strings.Contains(out, "loud.0  : listening on") {
+		t.Errorf("banner missing for loud.0:\n%s", out)
+	}
+}
+
+func TestLogFlushInterval(t *testing.T) {
+	t.Run("periodic", func(t *testing.T) {
+		var buf syncBuffer
+		r := New()
+		r.Output = &buf
+		r.LogFlushInterval = 100 * time.Millisecond
+		r.Processes = []*ProcessType{
+			{Name: "web", Cmd: []string{"echo hello from $PS; exec sleep 30"}},
+		}
+		stop := startRunner(t, &r)
+		defer stop()
+		if !eventually(t, func() bool { return strings.Contains(buf.String(), "hello from web.0") }) {
+			t.Error("buffered lines were not flushed")
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		var buf syncBuffer
+		r := New()
+		r.Output = &buf
+		r.WorkDir = tempDir(t)
+		r.LogFlushInterval = time.Hour
+		r.Processes = []*ProcessType{
+			{Name: "web", Cmd: []string{`echo hello from $PS; touch "$PS"; exec sleep 30`}},
+		}
+		stop := startRunner(t, &r)
+		ok := eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) })
+		time.Sleep(100 * time.Millisecond)
+		buffered := !strings.Contains(buf.String(), "hello from web.0")
+		stop()
+		if !ok {
+			t.Fatal("web.0 did not start")
+		}
+		if !buffered {
+			t.Error("lines were written before the flush interval")
+		}
+		if !strings.Contains(buf.String(), "hello from web.0") {
+			t.Error("buffered lines were not flushed on shutdown")
+		}
+	})
+}
+
+// BenchmarkPrefixedPrinter measures the cost of the output readers of a large
+// formation, each printing a single line.
+func BenchmarkPrefixedPrinter(b *testing.B) {
+	const instances = 200
+	r := New()
+	r.Processes = []*ProcessType{{Name: "worker", Cmd: []string{"true"}}}
+	r.Formation = map[string]int{"worker": instances}
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		wg.Add(instances)
+		for j := 0; j < instances; j++ {
+			pr, pw := io.Pipe()
+			name := fmt.Sprintf("worker.%d", j)
+			r.prefixedPrinter(ctx, pr, &ProcessType{Name: name}, name, name, ioutil.Discard, func(string) { wg.Done() })
+			go func() {
+				fmt.Fprintln(pw, "started")
+				pw.Close()
+			}()
+		}
+		wg.Wait()
+	}
+}
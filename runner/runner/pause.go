@@ -0,0 +1,132 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// restartPause holds the file changes while restarts are paused.
+type restartPause struct {
+	mu      sync.Mutex
+	paused  bool
+	held    bool
+	heldFn  string
+	resumed chan struct{}
+}
+
+// PauseRestarts suspends the builds and restarts triggered by file changes,
+// for instance to keep a debugging session attached to a process. The changes
+// detected meanwhile are applied once ResumeRestarts is called. Reloads (see
+// ProcessType.ReloadSignal) are suspended as well; Rebuild still works.
+func (r *Runner) PauseRestarts() {
+	r.restartPause.mu.Lock()
+	defer r.restartPause.mu.Unlock()
+	r.restartPause.paused = true
+}
+
+// ResumeRestarts resumes the builds and restarts triggered by file changes,
+// applying the changes detected while paused, if any.
+func (r *Runner) ResumeRestarts() {
+	p := &r.restartPause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	if p.held && p.resumed != nil {
+		select {
+		case p.resumed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *restartPause) hold(fn string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return false
+	}
+	p.held, p.heldFn = true, fn
+	return true
+}
+
+func (p *restartPause) take() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn, ok := p.heldFn, p.held
+	p.held, p.heldFn = false, ""
+	return fn, ok
+}
+
+// gateUpdates forwards the file changes to the returned channel, except while
+// restarts are paused. The last change held is forwarded once they resume.
+func (r *Runner) gateUpdates(ctx context.Context, updates <-chan string) <-chan string {
+	p := &r.restartPause
+	resumed := make(chan struct{}, 1)
+	p.mu.Lock()
+	p.resumed = resumed
+	p.mu.Unlock()
+
+	gated := make(chan string, cap(updates))
+	forward := func(fn string) {
+		select {
+		case gated <- fn:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case fn := <-updates:
+				if p.hold(fn) {
+					log.Println("restarts paused, holding change:", fn)
+					continue
+				}
+				forward(fn)
+			case <-resumed:
+				if fn, ok := p.take(); ok {
+					log.Println("restarts resumed, applying change:", fn)
+					forward(fn)
+				}
+			}
+		}
+	}()
+	return gated
+}
+
+// serveRestarts pauses (POST /restarts/pause) and resumes (POST
+// /restarts/resume) the restarts triggered by file changes.
+func (r *Runner) serveRestarts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch req.URL.Path {
+	case "/restarts/pause":
+		r.PauseRestarts()
+	case "/restarts/resume":
+		r.ResumeRestarts()
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
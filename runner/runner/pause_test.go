@@ -0,0 +1,86 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauseRestarts(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Observables = []string{"*.txt"}
+	trigger := filepath.Join(r.WorkDir, "trigger.txt")
+	if err := ioutil.WriteFile(trigger, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`echo >> builds`}},
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	builds := func() int {
+		b, _ := ioutil.ReadFile(filepath.Join(r.WorkDir, "builds"))
+		return strings.Count(string(b), "\n")
+	}
+	if !eventually(t, func() bool { return builds() == 1 }) {
+		t.Fatal("first build did not run")
+	}
+
+	r.PauseRestarts()
+	if err := ioutil.WriteFile(trigger, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := builds(); n != 1 {
+		t.Fatal("changes should be held while restarts are paused, builds:", n)
+	}
+
+	r.ResumeRestarts()
+	if !eventually(t, func() bool { return builds() == 2 }) {
+		t.Error("held changes should be applied once restarts are resumed, builds:", builds())
+	}
+}
+
+func TestServeRestarts(t *testing.T) {
+	r := New()
+	for _, tt := range []struct {
+		method, path string
+		code         int
+		paused       bool
+	}{
+		{http.MethodPost, "/restarts/pause", http.StatusNoContent, true},
+		{http.MethodGet, "/restarts/resume", http.StatusMethodNotAllowed, true},
+		{http.MethodPost, "/restarts/resume", http.StatusNoContent, false},
+		{http.MethodPost, "/restarts/bogus", http.StatusNotFound, false},
+	} {
+		w := httptest.NewRecorder()
+		r.serveRestarts(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: unexpected status. got: %d, want: %d", tt.method, tt.path, w.Code, tt.code)
+		}
+		if paused := r.restartPause.paused; paused != tt.paused {
+			t.Errorf("%s %s: unexpected pause state. got: %v, want: %v", tt.method, tt.path, paused, tt.paused)
+		}
+	}
+}
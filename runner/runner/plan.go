@@ -0,0 +1,135 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// plannedInstance is one instance of a non-build process type, as it is going
+// to be started by runNonBuilds.
+type plannedInstance struct {
+	proc     *ProcessType
+	name     string
+	instance int
+	port     int
+}
+
+// plan lists every instance of the non-build process types, along with their
+// designated IP ports. Each process type has 100 ports reserved to it,
+// starting from BasePort, in order of declaration.
+func (r *Runner) plan() []plannedInstance {
+	return r.planFor(r.processes())
+}
+
+// planFor is plan for the process types procs.
+func (r *Runner) planFor(procs []*ProcessType) []plannedInstance {
+	var instances []plannedInstance
+	for j, sv := range procs {
+		if strings.HasPrefix(sv.Name, "build") {
+			continue
+		}
+		for i := 0; i < r.formationCount(sv); i++ {
+			instances = append(instances, plannedInstance{
+				proc:     sv,
+				name:     fmt.Sprintf("%v.%v", sv.Name, i),
+				instance: i,
+				port:     r.basePort() + j*100 + i,
+			})
+		}
+	}
+	return instances
+}
+
+// buildPortCount returns the offset from BasePort of the IP port of the build
+// process type sv, or -1 if it does not set WantPort.
+func (r *Runner) buildPortCount(sv *ProcessType) int {
+	if !sv.WantPort {
+		return -1
+	}
+	for j, proc := range r.processes() {
+		if proc == sv {
+			return j * 100
+		}
+	}
+	return -1
+}
+
+func (r *Runner) basePort() int {
+	if r.BasePort == 0 {
+		return DefaultBasePort
+	}
+	return r.BasePort
+}
+
+func (r *Runner) formationCount(sv *ProcessType) int {
+	if formation, ok := r.Formation[sv.Name]; ok {
+		return formation
+	}
+	return 1
+}
+
+func (r *Runner) validatePlan(procs []*ProcessType) error {
+	assigned := make(map[int]string) // port to instance name
+	for _, inst := range r.planFor(procs) {
+		if inst.port < 1 || inst.port > 65535 {
+			return fmt.Errorf("%s: IP port %d is out of the valid range (1-65535)", inst.name, inst.port)
+		}
+		if other, ok := assigned[inst.port]; ok {
+			return fmt.Errorf("%s and %s are both assigned IP port %d", other, inst.name, inst.port)
+		}
+		assigned[inst.port] = inst.name
+	}
+	for j, proc := range procs {
+		if !isBuild(proc) || !proc.WantPort {
+			continue
+		}
+		if port := r.basePort() + j*100; port < 1 || port > 65535 {
+			return fmt.Errorf("%s: IP port %d is out of the valid range (1-65535)", proc.Name, port)
+		}
+	}
+	return nil
+}
+
+// assignedPorts maps the IP ports of the instances, and of the build process
+// types with WantPort, to the names of the processes they are assigned to.
+func (r *Runner) assignedPorts() map[int]string {
+	ports := make(map[int]string)
+	for _, inst := range r.plan() {
+		ports[inst.port] = inst.name
+	}
+	for _, proc := range r.processes() {
+		if pc := r.buildPortCount(proc); isBuild(proc) && pc > -1 {
+			ports[r.basePort()+pc] = proc.Name
+		}
+	}
+	return ports
+}
+
+// warnPortsInUse logs the planned IP ports that are already taken on the
+// host, as the instances assigned to them are likely to fail to bind.
+func (r *Runner) warnPortsInUse() {
+	for _, inst := range r.plan() {
+		l, err := net.Listen("tcp", fmt.Sprint("localhost:", inst.port))
+		if err != nil {
+			log.Printf("warning: %s is assigned IP port %d, which seems to be in use: %v", inst.name, inst.port, err)
+			continue
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errLivenessProbeFailed = errors.New("liveness probe failed")
+
+// Probe defines a periodic health check.
+type Probe struct {
+	// Target is either a network address, a process type name or a
+	// HTTP(S) URL. Network addresses and process type names are checked
+	// by opening a TCP connection. URLs are checked with a GET request,
+	// which must reply with a status code lower than 400.
+	Target string `json:"target"`
+
+	// Interval is the time between checks. Defaults to 1s.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// FailureThreshold is the number of consecutive failed checks after
+	// which the probe is considered failed. Defaults to 3.
+	FailureThreshold int `json:"failurethreshold,omitempty"`
+}
+
+func (p *Probe) interval() time.Duration {
+	if p.Interval <= 0 {
+		return time.Second
+	}
+	return p.Interval
+}
+
+func (p *Probe) failureThreshold() int {
+	if p.FailureThreshold <= 0 {
+		return 3
+	}
+	return p.FailureThreshold
+}
+
+// check runs the probe once.
+func (r *Runner) check(ctx context.Context, target string) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode < 400
+	}
+	c, err := r.dial(ctx, r.resolveProcessTypeAddress(target))
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// probeHealth runs the startup probe of sv, if any, until it passes, and then
+// its liveness probe, if any, until ctx is done. When either of them fails,
+// the returned channel is closed and stop is called.
+func (r *Runner) probeHealth(ctx context.Context, w io.Writer, sv *ProcessType, stop func()) <-chan struct{} {
+	failed := make(chan struct{})
+	go func() {
+		if sv.StartupProbe != nil {
+			if !r.probeStartup(ctx, w, sv.StartupProbe) {
+				if ctx.Err() == nil {
+					close(failed)
+					stop()
+				}
+				return
+			}
+			fmt.Fprintln(w, "startup probe passed on", sv.StartupProbe.Target)
+		}
+		if sv.LivenessProbe != nil && !r.probeLiveness(ctx, w, sv.LivenessProbe) && ctx.Err() == nil {
+			close(failed)
+			stop()
+		}
+	}()
+	return failed
+}
+
+// probeStartup checks the probe target until it is found healthy once, or
+// until the failure threshold is crossed.
+func (r *Runner) probeStartup(ctx context.Context, w io.Writer, p *Probe) bool {
+	for failures := 0; ; {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.clock().After(p.interval()):
+		}
+		if r.check(ctx, p.Target) {
+			return true
+		}
+		failures++
+		if failures >= p.failureThreshold() {
+			fmt.Fprintf(w, "startup probe failure on %s (%d/%d)\n", p.Target, failures, p.failureThreshold())
+			return false
+		}
+	}
+}
+
+// probeLiveness checks the probe target until ctx is done. Once the target is
+// found healthy, the failure threshold is enforced: when crossed, it returns
+// false.
+func (r *Runner) probeLiveness(ctx context.Context, w io.Writer, p *Probe) bool {
+	healthy, failures := false, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-r.clock().After(p.interval()):
+		}
+		if r.check(ctx, p.Target) {
+			healthy, failures = true, 0
+			continue
+		}
+		if !healthy {
+			continue
+		}
+		failures++
+		fmt.Fprintf(w, "liveness probe failure on %s (%d/%d)\n", p.Target, failures, p.failureThreshold())
+		if failures >= p.failureThreshold() {
+			return false
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLivenessProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	r := New()
+	r.Processes = []*ProcessType{
+		{
+			Name: "web",
+			Cmd:  []string{"exec sleep 30"},
+			LivenessProbe: &Probe{
+				Target:           l.Addr().String(),
+				Interval:         50 * time.Millisecond,
+				FailureThreshold: 2,
+			},
+		},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	starts := func() int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats["web.0"]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	if !eventually(t, func() bool { return starts() == 1 }) {
+		t.Fatal("web.0 did not start")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := starts(); n != 1 {
+		t.Fatal("healthy process should not be restarted, starts:", n)
+	}
+
+	l.Close()
+	if !eventually(t, func() bool { return starts() == 2 }) {
+		t.Fatal("unhealthy process was not restarted, starts:", starts())
+	}
+}
+
+func TestStartupProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	unreachable, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachableAddr := unreachable.Addr().String()
+	unreachable.Close()
+
+	var meta syncBuffer
+	r := New()
+	r.MetaOutput = &meta
+	r.Processes = []*ProcessType{
+		{
+			Name:          "slow",
+			Group:         "slow",
+			Cmd:           []string{"exec sleep 30"},
+			StartupProbe:  &Probe{Target: addr, Interval: 50 * time.Millisecond, FailureThreshold: 40},
+			LivenessProbe: &Probe{Target: addr, Interval: 50 * time.Millisecond, FailureThreshold: 2},
+		},
+		{
+			Name:         "stuck",
+			Group:        "stuck",
+			Cmd:          []string{"exec sleep 30"},
+			StartupProbe: &Probe{Target: unreachableAddr, Interval: 50 * time.Millisecond, FailureThreshold: 3},
+		},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+
+	// slow boots after a while, under the startup probe tolerance.
+	time.Sleep(500 * time.Millisecond)
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	if !eventually(t, func() bool { return strings.Contains(meta.String(), "slow.0  : startup probe passed") }) {
+		t.Fatal("startup probe did not pass:", meta.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := starts("slow.0"); n != 1 {
+		t.Error("slow-starting process should not be restarted, starts:", n)
+	}
+
+	if !eventually(t, func() bool { return starts("stuck.0") > 1 }) {
+		t.Error("process failing its startup probe should be restarted")
+	}
+
+	l.Close()
+	if !eventually(t, func() bool { return starts("slow.0") == 2 }) {
+		t.Error("liveness probe should take over after the startup probe, starts:", starts("slow.0"))
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNice(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "indexer", Cmd: []string{"exec sleep 30"}, Nice: 7},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	var got string
+	ok := eventually(t, func() bool {
+		r.liveMu.Lock()
+		p, ok := r.live["indexer.0"]
+		r.liveMu.Unlock()
+		if !ok {
+			return false
+		}
+		out, err := exec.Command("ps", "-o", "nice=", "-p", fmt.Sprint(p.Pid)).Output()
+		if err != nil {
+			return false
+		}
+		got = strings.TrimSpace(string(out))
+		return got == "7"
+	})
+	if !ok {
+		t.Errorf("unexpected niceness. got: %q, want: %q", got, "7")
+	}
+}
+
+func TestUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("running as another user requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("user nobody not found:", err)
+	}
+
+	r := New()
+	r.WorkDir = tempDir(t)
+	if err := os.Chmod(r.WorkDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}, User: "nobody"},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	var got string
+	ok := eventually(t, func() bool {
+		r.liveMu.Lock()
+		p, ok := r.live["web.0"]
+		r.liveMu.Unlock()
+		if !ok {
+			return false
+		}
+		out, err := exec.Command("ps", "-o", "uid=", "-p", fmt.Sprint(p.Pid)).Output()
+		if err != nil {
+			return false
+		}
+		got = strings.TrimSpace(string(out))
+		return got == nobody.Uid
+	})
+	if !ok {
+		t.Errorf("unexpected uid. got: %q, want: %q", got, nobody.Uid)
+	}
+}
+
+func TestUnknownUser(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}, User: "no-such-user-for-runner"},
+	}
+	if err := r.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "cannot find user") {
+		t.Error("unknown users should be reported when starting:", err)
+	}
+}
+
+func TestUmask(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	umask, procUmask := 027, 077
+	r.Umask = &umask
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"echo > artifact"}},
+		{Name: "web", Cmd: []string{"echo > private; exec sleep 30"}, Umask: &procUmask},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for file, want := range map[string]os.FileMode{"artifact": 0640, "private": 0600} {
+		path := filepath.Join(r.WorkDir, file)
+		if !eventually(t, func() bool { return fileExists(path) }) {
+			t.Fatal(file, "not created")
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s: unexpected mode %v, want %v", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(c *exec.Cmd) {}
+
+// terminateProcess kills the process right away, as Windows has no
+// equivalent to SIGTERM.
+func terminateProcess(p *os.Process) {
+	p.Kill()
+}
+
+func killProcess(p *os.Process) {
+	p.Kill()
+}
+
+func signalProcess(p *os.Process, sig syscall.Signal) error {
+	return p.Signal(sig)
+}
+
+func setNice(pid, nice int) error {
+	return errors.New("niceness is not supported on Windows")
+}
+
+func setCredential(c *exec.Cmd, cred credential) error {
+	return errors.New("running as another user is not supported on Windows")
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Types of ProgressEvent.
+const (
+	// ProgressBuildStarted is reported when a build process type starts.
+	ProgressBuildStarted = "build_started"
+	// ProgressBuildFinished is reported when a build process type
+	// finishes, with the Status "ok" or "failed".
+	ProgressBuildFinished = "build_finished"
+	// ProgressReady is reported when a process instance becomes ready.
+	ProgressReady = "ready"
+	// ProgressExited is reported when a process instance exits, with its
+	// ExitCode (-1 if terminated by a signal, ExitTerminated if stopped by
+	// the runner).
+	ProgressExited = "exited"
+	// ProgressAllReady is reported once all the process instances of a
+	// run are ready.
+	ProgressAllReady = "all_ready"
+)
+
+// ProgressEvent is a line of ProgressOutput.
+type ProgressEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+}
+
+func (r *Runner) reportProgress(ev ProgressEvent) {
+	if r.ProgressOutput == nil {
+		return
+	}
+	ev.Time = r.clock().Now()
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+	r.ProgressOutput.Write(b)
+}
+
+func (r *Runner) reportBuildFinished(name string, ok bool) {
+	status := "ok"
+	if !ok {
+		status = "failed"
+	}
+	r.reportProgress(ProgressEvent{Type: ProgressBuildFinished, Name: name, Status: status})
+}
+
+func (r *Runner) reportExited(name string, exitCode int) {
+	r.reportProgress(ProgressEvent{Type: ProgressExited, Name: name, ExitCode: &exitCode})
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgressOutput(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.ProgressOutput = &buf
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"true"}},
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+		{Name: "worker", Cmd: []string{"exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return strings.Contains(buf.String(), `"all_ready"`) })
+	stop()
+	if !ok {
+		t.Fatal("all_ready not reported:", buf.String())
+	}
+
+	var got []string
+	exitCodes := make(map[string]int)
+	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	for scanner.Scan() {
+		var ev ProgressEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatal("invalid progress line:", scanner.Text(), err)
+		}
+		if ev.Time.IsZero() {
+			t.Error("progress event without time:", scanner.Text())
+		}
+		if ev.Type == ProgressExited {
+			if ev.ExitCode == nil {
+				t.Error("exited event without exit code:", scanner.Text())
+			} else {
+				exitCodes[ev.Name] = *ev.ExitCode
+			}
+			continue
+		}
+		got = append(got, strings.TrimSpace(ev.Type+" "+ev.Name+" "+ev.Status))
+	}
+	want := []string{
+		"build_started build-web",
+		"build_finished build-web ok",
+		"ready web.0",
+		"ready worker.0",
+		"all_ready",
+	}
+	if len(got) == len(want) && got[2] == "ready worker.0" {
+		got[2], got[3] = got[3], got[2]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events. got: %q, want: %q", got, want)
+	}
+	if want := map[string]int{"web.0": ExitTerminated, "worker.0": ExitTerminated}; !reflect.DeepEqual(exitCodes, want) {
+		t.Errorf("unexpected exits. got: %v, want: %v", exitCodes, want)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWaitTimeout is returned by Start when processes do not become ready
+// within WaitTimeout.
+var ErrWaitTimeout = errors.New("processes did not become ready in time")
+
+// readiness tracks which process instances of the current generation are not
+// ready yet, and which process instances are ready.
+type readiness struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	ready   map[string]struct{}
+}
+
+func (rd *readiness) expect(names []string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.pending = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		rd.pending[name] = struct{}{}
+	}
+}
+
+// markReady flags the instance as ready, and reports whether it was the last
+// one of the current generation pending.
+func (rd *readiness) markReady(name string) (allReady bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	_, wasPending := rd.pending[name]
+	delete(rd.pending, name)
+	if rd.ready == nil {
+		rd.ready = make(map[string]struct{})
+	}
+	rd.ready[name] = struct{}{}
+	return wasPending && len(rd.pending) == 0
+}
+
+func (rd *readiness) markStopped(name string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	delete(rd.ready, name)
+}
+
+func (rd *readiness) isReady(name string) bool {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	_, ok := rd.ready[name]
+	return ok
+}
+
+func (rd *readiness) stuck() []string {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	var names []string
+	for name := range rd.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readyOnLogLine returns the output hook that marks the process instance
+// ready once a line matches its WaitForLog expression.
+func (r *Runner) readyOnLogLine(w io.Writer, sv *ProcessType, instance int, warm *warmRun) func(string) {
+	re := regexp.MustCompile(sv.WaitForLog)
+	var once sync.Once
+	return func(line string) {
+		if !re.MatchString(line) {
+			return
+		}
+		once.Do(func() {
+			fmt.Fprintln(w, "ready")
+			r.markReady(sv, instance, warm)
+		})
+	}
+}
+
+const (
+	// waitCommandInterval is the time between the runs of a WaitCommand.
+	waitCommandInterval = 250 * time.Millisecond
+
+	// waitCommandTimeout is how long each run of a WaitCommand is given to
+	// finish.
+	waitCommandTimeout = 10 * time.Second
+)
+
+// readyOnCommand runs the WaitCommand of sv, with the environment env, until
+// it succeeds, marking the process instance ready then. The returned function
+// stops the runs, and must be called once the ReadyCommand exits.
+func (r *Runner) readyOnCommand(ctx context.Context, w io.Writer, sv *ProcessType, instance int, warm *warmRun, env []string) (stop func()) {
+	var expect *regexp.Regexp
+	if sv.WaitCommandExpect != "" {
+		expect = regexp.MustCompile(sv.WaitCommandExpect)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fmt.Fprintln(w, "waiting for", `"`+sv.WaitCommand+`"`)
+		for {
+			if r.waitCommandSucceeds(ctx, sv.WaitCommand, expect, env) {
+				fmt.Fprintln(w, "ready")
+				r.markReady(sv, instance, warm)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.clock().After(waitCommandInterval):
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitCommandSucceeds runs cmd once, reporting whether it exits with code 0
+// and, if expect is not nil, its standard output, trimmed of the surrounding
+// whitespace, matches expect.
+func (r *Runner) waitCommandSucceeds(ctx context.Context, cmd string, expect *regexp.Regexp, env []string) bool {
+	ctx, cancel := context.WithTimeout(ctx, waitCommandTimeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, "sh", "-c", cmd)
+	c.Dir = r.WorkDir
+	c.Env = env
+	out, err := c.Output()
+	if err != nil {
+		return false
+	}
+	return expect == nil || expect.Match(bytes.TrimSpace(out))
+}
+
+// markReady flags the process instance as ready, reporting it. warm is the run
+// of the instance, if it has WarmRestart.
+func (r *Runner) markReady(sv *ProcessType, instance int, warm *warmRun) {
+	procName := fmt.Sprintf("%v.%v", sv.Name, instance)
+	allReady := r.readiness.markReady(procName)
+	if warm != nil {
+		warm.markReady()
+	}
+	r.setState(sv, instance, Ready)
+	r.reportProgress(ProgressEvent{Type: ProgressReady, Name: procName})
+	if allReady {
+		r.reportProgress(ProgressEvent{Type: ProgressAllReady})
+		if r.onAllReady != nil {
+			r.onAllReady()
+		}
+	}
+}
+
+// logReadinessTarget translates a WaitBefore or WaitFor target that names a
+// process type with WaitForLog or WaitCommand into the name of the instance to
+// wait for.
+func (r *Runner) logReadinessTarget(target string) (string, bool) {
+	for _, proc := range r.processes() {
+		if proc.WaitForLog == "" && proc.WaitCommand == "" {
+			continue
+		}
+		if target == proc.Name {
+			return proc.Name + ".0", true
+		}
+		if strings.HasPrefix(target, proc.Name+".") {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// watchReadiness reports through fatal the process instances that are not
+// ready once WaitTimeout is over, unless ctx is cancelled first.
+func (r *Runner) watchReadiness(ctx context.Context, fatal chan<- error) {
+	timer := r.clock().NewTimer(r.WaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C():
+	}
+	if stuck := r.readiness.stuck(); len(stuck) > 0 {
+		select {
+		case fatal <- fmt.Errorf("%w: %s", ErrWaitTimeout, strings.Join(stuck, ", ")):
+		default:
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := l.Addr().String()
+	l.Close()
+
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.WaitTimeout = 500 * time.Millisecond
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+		{Name: "worker", Cmd: []string{"exec sleep 30"}, WaitFor: unreachable},
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- r.Start(context.Background()) }()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrWaitTimeout) {
+			t.Fatal("unexpected error:", err)
+		}
+		if !strings.Contains(err.Error(), "worker.0") || strings.Contains(err.Error(), "web.0") {
+			t.Error("the error should name only the stuck process:", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start should have failed once the wait timeout was over")
+	}
+}
+
+func TestWaitForLog(t *testing.T) {
+	r := New()
+	r.WaitTimeout = 5 * time.Second
+	r.Processes = []*ProcessType{
+		{Name: "api", Cmd: []string{`sleep 0.5; touch said; echo "Server listening on $PORT"; exec sleep 30`}, WaitForLog: `listening on \d+`},
+		{Name: "client", Cmd: []string{`test -f said && touch started; exec sleep 30`}, WaitFor: "api"},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "started")) }) {
+		t.Fatal("client should have started once api printed its readiness line")
+	}
+	if !r.readiness.isReady("api.0") {
+		t.Error("api.0 should be ready")
+	}
+	if stuck := r.readiness.stuck(); len(stuck) > 0 {
+		t.Error("unexpected process instances not ready:", stuck)
+	}
+}
+
+func TestStopOnDependencyFailure(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "db", Cmd: []string{`echo "ready"; test -f web && test -f worker; sleep 0.5`}, WaitForLog: "ready"},
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}, WaitFor: "db", StopOnDependencyFailure: true},
+		{Name: "worker", Cmd: []string{`touch "$PS"; exec sleep 30`}, WaitFor: "db.0"},
+	}
+	running := func(name string) bool {
+		for _, st := range r.Status() {
+			if st.Name == name {
+				return st.Running
+			}
+		}
+		return false
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return running("web.0") && running("worker.0") }) {
+		t.Fatal("web and worker should have started once db was ready")
+	}
+	if !eventually(t, func() bool { return !running("db.0") && !running("web.0") }) {
+		t.Fatal("web should have been stopped once db stopped")
+	}
+	if !running("worker.0") {
+		t.Error("worker should keep running, as it does not set StopOnDependencyFailure")
+	}
+}
+
+func TestWaitCommand(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	health := filepath.Join(r.WorkDir, "health")
+	if err := ioutil.WriteFile(health, []byte("degraded\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "api", Cmd: []string{"exec sleep 30"}, WaitCommand: "cat health", WaitCommandExpect: "^ok$"},
+		{Name: "client", Cmd: []string{`touch "$PS"; exec sleep 30`}, WaitFor: "api"},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	time.Sleep(time.Second)
+	if r.readiness.isReady("api.0") {
+		t.Fatal("api.0 should not be ready while its wait command prints the wrong output")
+	}
+	if fileExists(filepath.Join(r.WorkDir, "client.0")) {
+		t.Fatal("client should wait for api to be ready")
+	}
+	if err := ioutil.WriteFile(health, []byte("ok\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(t, func() bool { return r.readiness.isReady("api.0") }) {
+		t.Fatal("api.0 should be ready once its wait command prints the expected output")
+	}
+	if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "client.0")) }) {
+		t.Error("client should have started once api was ready")
+	}
+}
+
+func TestValidateWaitCommand(t *testing.T) {
+	for _, proc := range []*ProcessType{
+		{Name: "web", Cmd: []string{"true"}, WaitCommandExpect: "ok"},
+		{Name: "web", Cmd: []string{"true"}, WaitCommand: "true", WaitCommandExpect: "("},
+		{Name: "web", Cmd: []string{"true"}, WaitCommand: "true", WaitForLog: "ready"},
+		{Name: "build", Cmd: []string{"true"}, WaitCommand: "true"},
+	} {
+		r := New()
+		r.Processes = []*ProcessType{proc}
+		if err := r.Validate(); err == nil {
+			t.Errorf("%+v should be rejected", proc)
+		}
+	}
+}
+
+func TestWaitForLogNotReady(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.WaitTimeout = 500 * time.Millisecond
+	r.Processes = []*ProcessType{
+		{Name: "api", Cmd: []string{`echo "starting"; exec sleep 30`}, WaitForLog: "listening"},
+	}
+	err := r.Start(context.Background())
+	if !errors.Is(err, ErrWaitTimeout) || !strings.Contains(err.Error(), "api.0") {
+		t.Error("api.0 should not be ready before the log line matches:", err)
+	}
+}
+
+func TestReadyCommand(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	cmds := []string{"true", "sleep 1", `touch "$PS.third"; exec sleep 30`}
+	r.Processes = []*ProcessType{
+		{Name: "second", Cmd: cmds, Group: "a", ReadyCommand: 2},
+		{Name: "last", Cmd: cmds, Group: "b"},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return r.readiness.isReady("second.0") }) {
+		t.Fatal("second.0 should be ready once its second command starts")
+	}
+	if fileExists(filepath.Join(r.WorkDir, "second.0.third")) {
+		t.Error("second.0 was marked ready too late")
+	}
+	if r.readiness.isReady("last.0") {
+		t.Error("last.0 should not be ready before its last command starts")
+	}
+	if !eventually(t, func() bool { return r.readiness.isReady("last.0") }) {
+		t.Error("last.0 should be ready once its last command starts")
+	}
+	if !r.readiness.isReady("second.0") {
+		t.Error("second.0 should remain ready while its last command runs")
+	}
+}
+
+func TestValidateReadyCommand(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"./migrate", "./web"}, ReadyCommand: 3},
+	}
+	err := r.Validate()
+	if err == nil {
+		t.Fatal("expected error missing")
+	}
+	const want = "web: ready command 3 is out of the valid range (1-2)"
+	if err.Error() != want {
+		t.Errorf("unexpected error message. got: %q, want: %q", err, want)
+	}
+}
+
+func TestWaitForFile(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "db", Cmd: []string{`sleep 0.5; touch db.lock; sleep 0.5; echo $$ > db.pid; exec sleep 30`}},
+		{Name: "web", Cmd: []string{`test -f db.lock && touch "$PS"; exec sleep 30`}, WaitForFile: "db.lock"},
+		{Name: "worker", Cmd: []string{`test -s db.pid && touch "$PS"; exec sleep 30`}, WaitForFile: "$PWD/db.pid", WaitForFileNotEmpty: true},
+	}
+	r.WorkDir = tempDir(t)
+	r.SetBaseEnvironment([]string{"PATH=" + os.Getenv("PATH"), "PWD=" + r.WorkDir})
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for _, name := range []string{"web.0", "worker.0"} {
+		if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, name)) }) {
+			t.Errorf("%s did not start once the file was ready", name)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	const readyAfter = 3
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, network+" "+addr)
+		if len(calls) < readyAfter {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}, WaitFor: "db.internal:5432"},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) }) {
+		t.Fatal("web did not start once the dialer reported the target as ready")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != readyAfter {
+		t.Errorf("unexpected dial count: %d, want: %d", len(calls), readyAfter)
+	}
+	for _, call := range calls {
+		if call != "tcp db.internal:5432" {
+			t.Error("unexpected dial:", call)
+		}
+	}
+}
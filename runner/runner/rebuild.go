@@ -0,0 +1,40 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrBuildFailed is returned by Rebuild when any of the build process types
+// fails.
+var ErrBuildFailed = errors.New("build failed")
+
+// Rebuild runs the build process types again, on demand, while the other
+// process types keep running. They are not restarted, neither after a
+// successful build, so the ones that reload their artifacts pick up the
+// changes, nor after a failed one, so the application stays up while the
+// failure is fixed. Cancelling ctx interrupts the builds.
+func (r *Runner) Rebuild(ctx context.Context) error {
+	runID := newRunID()
+	fmt.Fprintln(r.metaOutput(), "rebuilding on demand, run", runID)
+	if !r.runBuilds(withTraceParent(withRunID(ctx, runID)), "") {
+		fmt.Fprintln(r.metaOutput(), "error during on demand rebuild, services kept running")
+		return ErrBuildFailed
+	}
+	return nil
+}
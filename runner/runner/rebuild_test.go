@@ -0,0 +1,73 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRebuild(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Processes = []*ProcessType{
+		{Name: "build-assets", Cmd: []string{"echo >> builds; test ! -f fail"}},
+		{Name: "web", Cmd: []string{`echo $$ > "$PS.pid"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	pidFn := filepath.Join(r.WorkDir, "web.0.pid")
+	var pid []byte
+	if !eventually(t, func() bool {
+		pid, _ = ioutil.ReadFile(pidFn)
+		return len(pid) > 0
+	}) {
+		t.Fatal("web.0 did not start")
+	}
+	builds := func() int {
+		b, _ := ioutil.ReadFile(filepath.Join(r.WorkDir, "builds"))
+		return strings.Count(string(b), "\n")
+	}
+
+	if err := r.Rebuild(context.Background()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if n := builds(); n != 2 {
+		t.Error("unexpected build count after the rebuild:", n)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(r.WorkDir, "fail"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rebuild(context.Background()); !errors.Is(err, ErrBuildFailed) {
+		t.Error("expected build failure missing, got:", err)
+	}
+
+	if newPID, _ := ioutil.ReadFile(pidFn); string(newPID) != string(pid) {
+		t.Errorf("web.0 was restarted. PID got: %s, want: %s", newPID, pid)
+	}
+	r.liveMu.Lock()
+	p, ok := r.live["web.0"]
+	r.liveMu.Unlock()
+	if !ok || strings.TrimSpace(string(pid)) != fmt.Sprint(p.Pid) {
+		t.Error("web.0 is not running anymore")
+	}
+}
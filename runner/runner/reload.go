@@ -0,0 +1,97 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// parseSignal takes a signal name, with or without the "SIG" prefix (e.g.
+// "HUP" or "SIGHUP"), and converts to the signal.
+func parseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+func (r *Runner) setLiveProcess(procName string, p *os.Process) {
+	r.liveMu.Lock()
+	defer r.liveMu.Unlock()
+	if r.live == nil {
+		r.live = make(map[string]*os.Process)
+	}
+	if p == nil {
+		delete(r.live, procName)
+		return
+	}
+	r.live[procName] = p
+}
+
+// watchPatterns are all file patterns that the runner reacts to, either by
+// restarting process types or by reloading them.
+func (r *Runner) watchPatterns() []string {
+	patterns := append([]string(nil), r.Observables...)
+	for _, sv := range r.processes() {
+		patterns = append(patterns, sv.ReloadObservables...)
+	}
+	return patterns
+}
+
+// reload signals the process types whose ReloadObservables match the changed
+// file. It reports whether the file change was handled as a reload.
+func (r *Runner) reload(fn string) bool {
+	var reloaded bool
+	for _, sv := range r.processes() {
+		if !matchAny(sv.ReloadObservables, fn) {
+			continue
+		}
+		reloaded = true
+		sig, err := parseSignal(sv.ReloadSignal)
+		if err != nil {
+			fmt.Fprintln(r.metaOutput(), "cannot reload", sv.Name+":", err)
+			continue
+		}
+		r.liveMu.Lock()
+		for _, inst := range r.plan() {
+			if inst.proc != sv {
+				continue
+			}
+			p, ok := r.live[inst.name]
+			if !ok {
+				continue
+			}
+			fmt.Fprintln(r.metaOutput(), "reloading", inst.name, "with", sv.ReloadSignal, "after", fn, "changed")
+			if err := p.Signal(sig); err != nil {
+				fmt.Fprintln(r.metaOutput(), "cannot reload", inst.name+":", err)
+			}
+		}
+		r.liveMu.Unlock()
+	}
+	return reloaded
+}
+
+func matchAny(patterns []string, fn string) bool {
+	for _, p := range patterns {
+		if match(p, fn) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package runner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadSignal(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	conf := filepath.Join(r.WorkDir, "app.conf")
+	if err := ioutil.WriteFile(conf, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Observables = []string{"*.go"}
+	r.Processes = []*ProcessType{
+		{
+			Name: "web",
+			Cmd: []string{
+				`echo $$ > "$PS.pid"; trap 'echo $$ >> "$PS.reloads"' HUP; while true; do sleep 0.05; done`,
+			},
+			ReloadSignal:      "SIGHUP",
+			ReloadObservables: []string{"*.conf"},
+		},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	pidFn := filepath.Join(r.WorkDir, "web.0.pid")
+	var pid []byte
+	if !eventually(t, func() bool {
+		pid, _ = ioutil.ReadFile(pidFn)
+		return len(pid) > 0
+	}) {
+		t.Fatal("web.0 did not start")
+	}
+
+	if err := ioutil.WriteFile(conf, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadsFn := filepath.Join(r.WorkDir, "web.0.reloads")
+	var reloads []byte
+	if !eventually(t, func() bool {
+		reloads, _ = ioutil.ReadFile(reloadsFn)
+		return len(reloads) > 0
+	}) {
+		t.Fatal("web.0 did not receive the reload signal")
+	}
+	if string(reloads) != string(pid) {
+		t.Errorf("reload signal delivered to the wrong process. got: %s, want: %s", reloads, pid)
+	}
+	if newPID, _ := ioutil.ReadFile(pidFn); string(newPID) != string(pid) {
+		t.Errorf("web.0 was restarted. PID got: %s, want: %s", newPID, pid)
+	}
+}
+
+func TestReloadSignalScript(t *testing.T) {
+	umask := 0022
+	for _, tt := range []struct {
+		name  string
+		umask *int
+	}{
+		{"plain", nil},
+		{"umask", &umask},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = tempDir(t)
+			conf := filepath.Join(r.WorkDir, "app.conf")
+			if err := ioutil.WriteFile(conf, []byte("a"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			server := filepath.Join(r.WorkDir, "server.sh")
+			script := "#!/bin/sh\n" +
+				`echo $$ > "$PS.pid"` + "\n" +
+				`trap 'echo $$ >> "$PS.reloads"' HUP` + "\n" +
+				"while true; do sleep 0.05; done\n"
+			if err := ioutil.WriteFile(server, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			r.Umask = tt.umask
+			r.Processes = []*ProcessType{
+				{
+					Name:              "web",
+					Cmd:               []string{server},
+					ReloadSignal:      "HUP",
+					ReloadObservables: []string{"*.conf"},
+				},
+			}
+			stop := startRunner(t, &r)
+			defer stop()
+
+			pidFn := filepath.Join(r.WorkDir, "web.0.pid")
+			var pid []byte
+			if !eventually(t, func() bool {
+				pid, _ = ioutil.ReadFile(pidFn)
+				return len(pid) > 0
+			}) {
+				t.Fatal("web.0 did not start")
+			}
+
+			if err := ioutil.WriteFile(conf, []byte("b"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			reloadsFn := filepath.Join(r.WorkDir, "web.0.reloads")
+			var reloads []byte
+			if !eventually(t, func() bool {
+				reloads, _ = ioutil.ReadFile(reloadsFn)
+				return len(reloads) > 0
+			}) {
+				t.Fatal("the script did not receive the reload signal")
+			}
+			if string(reloads) != string(pid) {
+				t.Errorf("reload signal delivered to the wrong process. got: %s, want: %s", reloads, pid)
+			}
+			for _, st := range r.Status() {
+				if st.Name == "web.0" && (!st.Running || st.Starts != 1) {
+					t.Errorf("web.0 should still be running its first start: %+v", st)
+				}
+			}
+		})
+	}
+}
@@ -20,16 +20,24 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	supervisor "cirello.io/supervisor/easy"
 )
@@ -39,24 +47,57 @@ import (
 // normalizes them.
 var ErrNonUniqueProcessTypeName = errors.New("non unique process type name")
 
+// errStartTimeout is returned by startProcess when a command is stopped for
+// not producing any output within the process type StartTimeout.
+var errStartTimeout = errors.New("start timeout reached")
+
+// errMaxRuntimeReached is returned by startProcess when the instance is
+// stopped for outliving the process type MaxRuntime.
+var errMaxRuntimeReached = errors.New("maximum runtime reached")
+
 // RestartMode defines if a process should restart itself.
 type RestartMode string
 
 // ParseRestartMode takes a string and converts to RestartMode. If the parsing
 // fails, it silently defaults to Never.
 func ParseRestartMode(m string) RestartMode {
+	mode, _ := ParseRestartModeStrict(m)
+	return mode
+}
+
+// ParseRestartModeStrict takes a string and converts to RestartMode. Unlike
+// ParseRestartMode, it fails on unknown restart modes, so typos are not
+// mistaken for Never.
+func ParseRestartModeStrict(m string) (RestartMode, error) {
 	switch strings.ToLower(m) {
 	case "yes", "always", "true", "1":
-		return Always
+		return Always, nil
 	case "fail", "failure", "onfail", "onfailure", "on-failure", "on_failure":
-		return OnFailure
+		return OnFailure, nil
 	case "temporary", "start-once", "temp", "tmp":
-		return Temporary
+		return Temporary, nil
+	case "", "no", "never", "false", "0":
+		return Never, nil
 	default:
-		return Never
+		return Never, fmt.Errorf("unknown restart mode %q", m)
 	}
 }
 
+// UnmarshalJSON decodes the restart mode with ParseRestartModeStrict, so
+// configurations with unknown restart modes fail to load.
+func (m *RestartMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	mode, err := ParseRestartModeStrict(s)
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
 // Restart modes
 const (
 	Always    RestartMode = "yes"
@@ -86,13 +127,36 @@ type ProcessType struct {
 
 	// WaitBefore is the network address or process type name that the
 	// process type waits to be available before initiating the process type
-	// start.
+	// start. $VAR and ${VAR} are expanded with the runner environment
+	// overlaid with the BaseEnvironment; unset variables expand to empty. The shell forms ${VAR:-default}, ${VAR-default},
+	// ${VAR:?message} and ${VAR?message} are supported too, the latter
+	// two failing the start if VAR is not set.
 	WaitBefore string `json:"waitbefore,omitempty"`
 
 	// WaitFor is the network address or process type name that the process
-	// type waits to be available before finalizing the start.
+	// type waits to be available before finalizing the start. Variables
+	// are expanded as in WaitBefore.
 	WaitFor string `json:"waitfor,omitempty"`
 
+	// WaitForFile is the path of a file, like a lock, PID or socket file
+	// created by another process type, whose existence the process type
+	// waits for before finalizing the start, after WaitFor. Relative paths
+	// are resolved against the runner's WorkDir, and variables are
+	// expanded as in WaitBefore.
+	WaitForFile string `json:"waitforfile,omitempty"`
+
+	// WaitForFileNotEmpty makes the process type also wait for the file of
+	// WaitForFile to have some content.
+	WaitForFileNotEmpty bool `json:"waitforfilenotempty,omitempty"`
+
+	// StopOnDependencyFailure stops the instances of the process type once
+	// a process instance they wait for, through WaitBefore or WaitFor by
+	// process type or instance name, stops for good: that is, it exits
+	// and its Restart mode does not restart it, or the runner gave up on
+	// it (see MaxRestarts). The stopped instances are then restarted as
+	// their own Restart mode dictates, waiting again for the dependency.
+	StopOnDependencyFailure bool `json:"stopondependencyfailure,omitempty"`
+
 	// Restart is the flag that forces the process type to restart. It means
 	// that all steps are executed upon restart. This option does not apply
 	// to build steps.
@@ -110,12 +174,234 @@ type ProcessType struct {
 
 	// Sticky processes are not interrupted by filesystem events.
 	Sticky bool
+
+	// DependsOn are the names of the build process types that must succeed
+	// before this build process type starts. Builds without dependencies
+	// between them run in parallel, up to MaxBuildParallelism. If any of
+	// the dependencies fails, the build process type is skipped, failing
+	// the build. Dependency cycles are reported by Validate. Not available
+	// to non-build process types.
+	DependsOn []string `json:"dependson,omitempty"`
+
+	// Artifacts are the files that the build process type must produce,
+	// relative to WorkDir. Once its commands succeed, the build still
+	// fails if any of them is missing or empty, catching builds that
+	// silently do nothing. Not available to non-build process types.
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// WantPort assigns the build process type an IP port, like the first
+	// instance of the other process types: it is set to $PORT and
+	// published on the service discovery as BUILD_NAME_PORT. Useful for
+	// builds that run integration tests. Not available to non-build
+	// process types, which always earn an IP port.
+	WantPort bool `json:"wantport,omitempty"`
+
+	// RestartExitCodes, when set, overrides the Restart mode on the decision
+	// of restarting the process type after it exits: it is restarted only
+	// if its exit code is one of these. The Restart mode still defines
+	// whether the process type is restarted on rebuilds.
+	RestartExitCodes []int `json:"restartexitcodes,omitempty"`
+
+	// LivenessProbe periodically checks whether the process type instance
+	// is still healthy, once it is started. An instance that is healthy
+	// and then fails the probe too many times in a row is stopped and
+	// restarted, regardless of the Restart mode.
+	LivenessProbe *Probe `json:"livenessprobe,omitempty"`
+
+	// StartupProbe checks whether the process type instance finished
+	// starting up. While it has not passed, the liveness probe is not
+	// checked, so slow-starting processes are not restarted. If it fails
+	// too many times in a row, the instance is stopped and restarted,
+	// regardless of the Restart mode. Once it passes, it is not checked
+	// again and the liveness probe takes over.
+	StartupProbe *Probe `json:"startupprobe,omitempty"`
+
+	// ReloadSignal is the signal (e.g. "HUP" or "SIGUSR1") sent to the
+	// running instances of the process type when a file matching
+	// ReloadObservables changes. Simple commands replace their shell, so
+	// they receive it themselves; commands with lists or pipelines leave
+	// it to the shell that runs them.
+	ReloadSignal string `json:"reloadsignal,omitempty"`
+
+	// ReloadObservables are the filepath.Match() patterns of files that,
+	// when changed, trigger a reload (see ReloadSignal) instead of a
+	// restart. They are watched in addition to the runner Observables.
+	// Changes to these files do not trigger builds nor restarts.
+	ReloadObservables []string `json:"reloadobservables,omitempty"`
+
+	// EnvFiles are environment files loaded for this process type only.
+	// Relative paths are resolved against the runner's WorkDir. Their
+	// variables take precedence over BaseEnvironment, and are loaded in
+	// order of declaration, so the latter files take precedence over the
+	// former ones. The variables injected by the runner (PS, PORT,
+	// DISCOVERY...) cannot be overridden.
+	EnvFiles []string `json:"envfiles,omitempty"`
+
+	// Env are environment variables, in the format VARIABLENAME=VALUE, set
+	// for this process type only. They take precedence over
+	// BaseEnvironment and EnvFiles, and within Env the latter occurrences
+	// of a variable take precedence over the former ones.
+	Env []string `json:"env,omitempty"`
+
+	// SecretCommand is a command, executed directly rather than by sh,
+	// whose output lines in the format VARIABLENAME=VALUE are added to the
+	// environment of the process type commands, taking precedence over
+	// EnvFiles. It lets secrets come from tools like vault or pass instead
+	// of plain text files. It runs as the user of the runner, in WorkDir,
+	// before each start of the instances, and it is given 30 seconds to
+	// finish; if it fails, the instance is not started. The values of at
+	// least 4 characters are redacted from the output of the process type.
+	SecretCommand []string `json:"secretcommand,omitempty"`
+
+	// CPUAffinity pins the process type to the given CPU cores, numbered
+	// from 0. It is applied right after the activating command starts and
+	// inherited by the processes it spawns afterwards. Only supported on
+	// Linux; elsewhere, a warning is printed and the setting is ignored.
+	CPUAffinity []int `json:"cpuaffinity,omitempty"`
+
+	// Nice is the scheduling priority of the process type commands, from
+	// -20 (highest priority) to 19 (lowest priority). Zero keeps the
+	// niceness of the runner. Negative values usually require privileges.
+	// Not supported on Windows, where the setting is ignored with a
+	// warning.
+	Nice int `json:"nice,omitempty"`
+
+	// WaitForLog is a regular expression that, when set, defines when the
+	// process type is ready: once a line of its output matches it, instead
+	// of as soon as its ReadyCommand starts. Other process types waiting
+	// for it by name (see WaitBefore and WaitFor) wait for the match,
+	// instead of for network readiness.
+	WaitForLog string `json:"waitforlog,omitempty"`
+
+	// WaitCommand is a command, interpreted by sh, that when set defines
+	// when the process type is ready: once it succeeds, instead of as soon
+	// as its ReadyCommand starts. It is run in WorkDir, with the
+	// environment of the ReadyCommand, right after the latter starts and
+	// then every 250ms, each run given up to 10 seconds. It succeeds when it
+	// exits with code 0 and, if WaitCommandExpect is set, its standard
+	// output matches WaitCommandExpect. Other process types waiting for it by
+	// name wait for it to succeed, as with WaitForLog, which it cannot be
+	// combined with.
+	WaitCommand string `json:"waitcommand,omitempty"`
+
+	// WaitCommandExpect is a regular expression that the standard output
+	// of WaitCommand, trimmed of the surrounding whitespace, must match for
+	// it to succeed, catching the health checks that exit with code 0 while
+	// reporting failures.
+	WaitCommandExpect string `json:"waitcommandexpect,omitempty"`
+
+	// ReadyCommand is the number, starting from 1, of the command whose
+	// start makes the process type ready, for instance when the last
+	// command is a brief task that follows the start of the service. The
+	// instance remains ready until its last command finishes. Zero means
+	// the last command.
+	ReadyCommand int `json:"readycommand,omitempty"`
+
+	// NoBanner suppresses the lines printed before each command starts
+	// (`running "cmd"`, `listening on PORT` and a blank line), while
+	// keeping the output of the process type.
+	NoBanner bool `json:"nobanner,omitempty"`
+
+	// Label replaces the process type name in the prefix of its output
+	// lines, for instance to shorten long names: with the label "resizer",
+	// the instance "background-image-resizer.0" is printed as "resizer.0".
+	// Everything else, like the service discovery and the log streams,
+	// still refers to the process type by its name.
+	Label string `json:"label,omitempty"`
+
+	// User is the user, and optionally the group, the process type runs
+	// as, in the format "user[:group]". Both can be names or numeric ids,
+	// and they are resolved when the runner starts. If the group is
+	// omitted, the primary group of the user is used; if the user is
+	// omitted (":group"), the user of the runner is kept. Changing users
+	// usually requires privileges. Not supported on Windows.
+	User string `json:"user,omitempty"`
+
+	// Limits are the resource limits (memory and CPU time) of the
+	// process type commands.
+	Limits *Limits `json:"limits,omitempty"`
+
+	// Umask is the file mode creation mask of the process type commands
+	// (e.g. 022), so the files they create have the same permissions
+	// regardless of the umask the runner was started with. It takes
+	// precedence over the runner's Umask. Like Limits, it is set by the
+	// shell right before executing each command; it has no effect on
+	// Windows.
+	Umask *int `json:"umask,omitempty"`
+
+	// StdinFile is a file whose content is fed to the standard input of
+	// each command of the process type. Relative paths are resolved
+	// against the runner's WorkDir. If not set, commands have no standard
+	// input.
+	StdinFile string `json:"stdinfile,omitempty"`
+
+	// PathPrepend are directories prepended to the PATH of the process
+	// type commands, in order of declaration, so their executables take
+	// precedence over the ones elsewhere. Relative paths are resolved
+	// against the runner's WorkDir.
+	PathPrepend []string `json:"pathprepend,omitempty"`
+
+	// MinHealthyUptime is how long an instance must stay up for its start
+	// to be considered successful. Each instance that exits sooner, on its
+	// own, has its next restart delayed, doubling the delay (up to
+	// MinHealthyUptime) on each consecutive early exit. Once an instance
+	// outlives MinHealthyUptime, the delay is reset. Zero restarts
+	// instances right away.
+	MinHealthyUptime time.Duration `json:"minhealthyuptime,omitempty"`
+
+	// MaxRestarts is the maximum number of times an instance is restarted
+	// by the runner after exiting, within RestartWindow. Once exceeded,
+	// the runner logs that it is giving up on the instance, and does not
+	// start it again until the next rebuild, even when the rest of its
+	// group restarts. Zero means no limit.
+	MaxRestarts int `json:"maxrestarts,omitempty"`
+
+	// RestartWindow is the sliding period within which restarts count
+	// towards MaxRestarts; older restarts are forgotten. Zero means that
+	// all restarts since the instance was started by a rebuild count.
+	RestartWindow time.Duration `json:"restartwindow,omitempty"`
+
+	// MaxRuntime is how long an instance is allowed to run before the
+	// runner stops it, gracefully and then forcibly (see
+	// ShutdownGracePeriod). With Restart set to Always, instances are
+	// recycled periodically; otherwise, it works as a timeout, and the
+	// stop is not considered a failure. Stops by MaxRuntime do not count
+	// towards MaxRestarts. Zero means no limit.
+	MaxRuntime time.Duration `json:"maxruntime,omitempty"`
+
+	// StartTimeout is how long each command of the process type is given
+	// to output its first line once started, regardless of WaitTimeout.
+	// Commands that hang before doing anything, for instance waiting on a
+	// lock, are stopped once it expires as in MaxRuntime, and the stop is
+	// considered a failure. Zero means no limit.
+	StartTimeout time.Duration `json:"starttimeout,omitempty"`
+
+	// Schedule, when set, makes the runner start the process type on a
+	// schedule, instead of keeping it running: either at an interval
+	// ("5m" or "@every 5m") or at the times of a cron expression with the
+	// five standard fields ("*/5 * * * *"), in local time. The runs due
+	// while the previous one is still going are skipped. Restart does not
+	// apply to scheduled process types. Not available to build process
+	// types.
+	Schedule string `json:"schedule,omitempty"`
+
+	// WarmRestart, when set, keeps the running instances of the process
+	// type alive across the restarts triggered by file changes until
+	// their replacements are ready, so they can share the listening
+	// socket and no connection is refused while the application restarts.
+	// It requires cooperation from the application: its instances have
+	// SO_REUSEPORT=1 in their environment and must bind $PORT with the
+	// SO_REUSEPORT socket option. The replacements are ready as described
+	// in WaitForLog, which should match a line logged once they are
+	// accepting connections. Not available to build process types.
+	WarmRestart bool `json:"warmrestart,omitempty"`
 }
 
 // Runner defines how this application should be started.
 type Runner struct {
 	// WorkDir is the working directory from which all commands are going
-	// to be executed.
+	// to be executed. Variables are expanded, as in WaitBefore, when the
+	// runner starts.
 	WorkDir string `json:"workdir,omitempty"`
 
 	// Observables are the filepath.Match() patterns used to scan for files
@@ -126,48 +412,527 @@ type Runner struct {
 	// scanning.
 	SkipDirs []string `json:"skipdir,omitempty"`
 
+	// SkipFiles are the filepath.Match() patterns of the files whose
+	// changes are ignored, even if they match Observables.
+	SkipFiles []string `json:"skipfiles,omitempty"`
+
 	// Processes is the list of processes necessary to start this
 	// application.
 	Processes []*ProcessType `json:"procs"`
 
+	// Include are JSON configuration files whose process types are merged
+	// into Processes by Load, after the ones declared here, in order.
+	// Relative paths are resolved against the directory of the file that
+	// includes them, and included files may include others. Only the
+	// process types are taken from the included files.
+	Include []string `json:"include,omitempty"`
+
 	// BasePort is the IP port number used to calculate an IP port for each
 	// process type and set to its $PORT environment variable. Build
-	// processes do not earn an IP port.
+	// processes do not earn an IP port, unless they set WantPort. Each
+	// process type has 100 IP ports reserved to its instances, in order of
+	// declaration. All calculated IP ports must be within the range
+	// 1-65535. Zero means DefaultBasePort.
 	BasePort int
 
 	// Formation allows to start more than one process type each time. Each
 	// start will yield its own exclusive $PORT. Formation does not apply
-	// to build process types.
+	// to build process types. When starting, environment variables named
+	// after the process type with the suffix "_CONCURRENCY" (for
+	// example, WEB_CONCURRENCY=3) take precedence over this configuration.
 	Formation map[string]int // map of process type name and count
 
+	// GroupStrategies sets how each group reacts to the restart of one of
+	// its process instances, OneForAll unless set otherwise. The empty
+	// group name refers to the process types without a group.
+	GroupStrategies map[string]GroupStrategy // map of group name to its strategy
+
 	// BaseEnvironment is the set of environment variables loaded into
-	// the service.
+	// the service, on top of the environment of the runner. Once the
+	// runner is started, use SetBaseEnvironment to change it.
 	BaseEnvironment []string
 
-	longestProcessTypeName int
+	// Labels are metadata, like a tenant or environment identifier, passed
+	// to every process as variables prefixed by "RUNNER_LABEL_" and named
+	// after the label, normalized like the service discovery variables
+	// (e.g. {"tenant": "acme"} becomes RUNNER_LABEL_TENANT=acme). They are
+	// logged when the runner starts, and reported by Status.
+	Labels map[string]string `json:"labels,omitempty"`
 
 	// ServiceDiscoveryAddr is the net.Listen address used to bind the
 	// service discovery service. Set to empty to disable it. If activated
 	// this address is passed to the processes through the environment
-	// variable named "DISCOVERY".
+	// variable named "DISCOVERY". The path "/logs" of this service streams
+	// the output of the processes, optionally filtered by the query
+	// parameter "proc" (e.g. "/logs?proc=web"), and the path "/procs"
+	// lists the process instances.
 	ServiceDiscoveryAddr string
 
+	// Mux, if set, is where the HTTP services of the runner are registered,
+	// instead of on a listener of their own, so they can share a server
+	// with other services. The process types ports are then served on
+	// "/discovery", next to "/logs", "/procs", "/kv/" and "/restarts/",
+	// all under MuxPrefix. ServiceDiscoveryAddr must be set to the address
+	// where Mux is served: it is not listened on, and DISCOVERY is set to
+	// it with the "/discovery" path (e.g. "localhost:8080/discovery").
+	// The services are registered on the first Start only, and keep
+	// serving the runner across the later ones.
+	Mux *http.ServeMux `json:"-"`
+
+	// MuxPrefix is the path under which the HTTP services are registered on
+	// Mux (e.g. "/runner", for "/runner/discovery" and "/runner/logs"),
+	// so they do not collide with the routes of the program. Empty
+	// registers them at the root of Mux.
+	MuxPrefix string `json:"-"`
+
+	// DialContext, if set, opens the connections of the TCP readiness
+	// checks, of WaitFor targets and probes, for instance to route them
+	// through a proxy. If nil, the standard net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error) `json:"-"`
+
+	// CommandFactory, if set, creates the commands of the process types,
+	// for instance to sandbox them with wrappers like nsenter or
+	// firejail. It receives the shell and the command line for it to
+	// interpret, already accounting for Umask and Limits. The runner then
+	// sets the environment, the standard streams and the process group
+	// of the command, and its working directory unless already set. As
+	// the runner stops the commands on its own, use exec.Command rather
+	// than exec.CommandContext. If nil, commands are created with
+	// exec.Command(shell, "-c", cmd).
+	CommandFactory func(ctx context.Context, shell, cmd string) *exec.Cmd `json:"-"`
+
+	// TruncateLines is the maximum length in bytes of each line of output.
+	// Longer lines are cut and marked as truncated. Zero means no limit.
+	// Lines longer than the internal buffer of 2MB are still reported as
+	// errors.
+	TruncateLines int
+
+	// LevelPattern, if set, is the regular expression that extracts the
+	// severity level of each line of output: its first submatch or, if it
+	// has none, the whole match (see DefaultLevelPattern). The levels are
+	// reported on the JSON stream of "/logs" (e.g. "/logs?format=json").
+	LevelPattern string
+
+	// ColorLevels highlights the lines at warning level and above, as
+	// parsed by LevelPattern, when the output is colorized.
+	ColorLevels bool
+
+	// Tracer traces the builds, readiness waits and process starts. If
+	// nil, tracing is disabled.
+	Tracer Tracer `json:"-"`
+
+	// Summary prints, once the runner is stopped, a report with the
+	// restart count, the last exit code and the total uptime of each
+	// process.
+	Summary bool
+
+	// MaxBuildParallelism is the maximum number of build process types
+	// running at the same time. Zero means that all of them run at once.
+	MaxBuildParallelism int
+
+	// MaxRestartRate is the maximum number of restarts per minute across
+	// all process types, a safety valve for systemic failures. Once
+	// exceeded, the runner warns and holds all restarts until the rate of
+	// the last minute falls back under it. Zero means no limit.
+	MaxRestartRate int
+
+	// RestartBackoffMin and RestartBackoffMax delay the restarts of the
+	// instances that exit on their own: each restart waits
+	// RestartBackoffMin, doubled on each consecutive exit that happens
+	// before the instance stayed up for RestartBackoffMax, up to
+	// RestartBackoffMax. The delays are shortened by up to a fifth, at
+	// random, so the instances failing together do not restart in
+	// lockstep. Each instance keeps its own delay, and process types
+	// setting MinHealthyUptime follow it instead. Zero RestartBackoffMin
+	// restarts instances right away; RestartBackoffMax is raised to
+	// RestartBackoffMin if lower.
+	RestartBackoffMin time.Duration
+	RestartBackoffMax time.Duration
+
+	// PostBuildDelay is the pause between the completion of the builds and
+	// the start of the other process types, for instance to let generated
+	// files settle. Zero means no pause.
+	PostBuildDelay time.Duration
+
+	// Umask is the file mode creation mask of the commands of the process
+	// types that do not set their own. If nil, the commands inherit the
+	// umask of the runner.
+	Umask *int
+
+	// PidFile is the path of the file into which the runner writes its
+	// process ID when started. It is removed once the runner stops.
+	PidFile string
+
+	// SkipBuilds starts the process types without running the build
+	// process types first. Keeping the build artifacts up to date is then up
+	// to the user, as stale artifacts are used as they are.
+	SkipBuilds bool
+
+	// WatchOnly monitors the WorkDir and prints the changes detected, but
+	// does not start any process type. It helps tuning Observables and
+	// SkipDirs.
+	WatchOnly bool
+
+	// DisableWatch does not monitor the WorkDir at all: the processes are
+	// started once, and restarted only as their Restart modes dictate,
+	// never on file changes. Useful where the restarts are handled by
+	// other means, like in containers. Setting the environment variable
+	// RUNNER_NO_WATCH=1 has the same effect.
+	DisableWatch bool
+
+	// ConfigPath is the configuration file from which the runner was
+	// loaded, watched for changes with WatchConfig.
+	ConfigPath string `json:"-"`
+
+	// WatchConfig makes the runner watch ConfigPath and, once it changes,
+	// replace its process types with the ones of the new configuration,
+	// restarting them as upon the change of an observed file. The other
+	// settings are not reloaded. If the new configuration cannot be loaded
+	// or is not valid, the runner keeps running the current one. Temporary
+	// process types are not restarted, so the ones added by a reload are
+	// not started. It requires file watching (see DisableWatch).
+	WatchConfig bool `json:"watchconfig,omitempty"`
+
+	// LoadConfig reads the process types of the configuration file fn for
+	// WatchConfig. If nil, fn is loaded as a JSON configuration (see Load).
+	LoadConfig func(fn string) ([]*ProcessType, error) `json:"-"`
+
+	// Output is where the output of the processes, prefixed with their
+	// names, and the summary are written to. If nil, os.Stdout is used.
+	Output io.Writer `json:"-"`
+
+	// MetaOutput is where the runner's own messages about each process
+	// (e.g. "running ...", "waiting for ...") are written to. If nil, they
+	// are written to Output, interleaved with the output of the processes.
+	MetaOutput io.Writer `json:"-"`
+
+	// ForwardSignals are the signals (e.g. "USR1" or "SIGUSR1") that the
+	// runner relays to the running process types, along with the
+	// processes they spawned, instead of handling them itself. As with
+	// ReloadSignal, commands with lists or pipelines receive them in their
+	// shell too, which terminates on the signals it does not trap. SIGINT
+	// and SIGTERM cannot be forwarded, as they stop the runner.
+	ForwardSignals []string
+
+	// LogFlushInterval, when set, buffers the lines written to Output and
+	// MetaOutput, flushing them on this interval and when the runner stops.
+	// It batches small writes, for instance when Output is a file on a
+	// slow disk, at the cost of lines being delayed. Zero writes each line
+	// right away.
+	LogFlushInterval time.Duration
+
+	// ProgressOutput, if set, receives a JSON object per line (see
+	// ProgressEvent) for each key event of the runs: builds starting and
+	// finishing, process instances becoming ready and exiting, and all of
+	// them being ready. It is meant for CI systems to follow the progress
+	// without parsing the output.
+	ProgressOutput io.Writer `json:"-"`
+
+	// WaitTimeout is the maximum time the process instances of each
+	// generation are given to become ready, that is, to start their
+	// ReadyCommand once done waiting for WaitBefore and WaitFor. If any of them
+	// is not ready by then, the runner is stopped and Start returns
+	// ErrWaitTimeout naming them. Zero means no timeout.
+	WaitTimeout time.Duration
+
+	// ExitWhenAllStopped makes Start return once all the process instances
+	// exited and are not going to be restarted, for one-shot
+	// orchestrations. Start returns nil if all of them exited successfully,
+	// or ErrProcessesFailed naming the ones that did not. It cannot be
+	// combined with process types that restart always or run on a
+	// Schedule, as they never stop for good. File changes restart the
+	// processes as usual, and only the latest generation is accounted for.
+	ExitWhenAllStopped bool
+
+	// ShutdownGracePeriod is how long processes are given to exit once
+	// asked to terminate (SIGTERM on Unix), both when the runner stops and
+	// when they are restarted, before being killed. Zero means that they
+	// are killed right away. See ForceStop.
+	ShutdownGracePeriod time.Duration
+
+	// OnStateChange, if set, is called on each state transition of the
+	// process instances, with the process type name and the instance
+	// number (-1 for builds). It is called from a dedicated goroutine, one
+	// transition at a time and in order, so it is safe to call the
+	// Runner methods from it, and a slow callback delays the next
+	// transitions reports, but not the processes.
+	OnStateChange func(name string, instance int, from, to State) `json:"-"`
+
+	muxOnce                 sync.Once
 	sdMu                    sync.Mutex
 	dynamicServiceDiscovery map[string]string
 	staticServiceDiscovery  []string
 	currentGeneration       int
+
+	baseEnvMu sync.Mutex
+
+	buildMu sync.Mutex // serializes the runs of the build process types
+
+	buildEnvMu     sync.Mutex
+	buildEnv       map[string][]string // map of build name to its exported environment
+	buildEnvOutput []string
+
+	liveMu sync.Mutex
+	live   map[string]*os.Process // map of process name to its activating command
+
+	logs       logHub
+	out        lineWriter
+	levelRE    *regexp.Regexp // compiled LevelPattern, nil if not set
+	readiness  readiness
+	fatal      chan error
+	allStopped chan error // see ExitWhenAllStopped
+	timeSource clock      // see clock
+	onAllReady func()     // see Chain
+	states     stateChanges
+
+	progressMu sync.Mutex
+
+	kv           kvStore
+	restartPause restartPause
+	restartRate  restartRate
+	secrets      secretRedactions
+	warmRuns     warmRuns
+
+	statsMu sync.Mutex
+	stats   map[string]*processStats // map of process name to its stats
+
+	lastBuildOK bool
+	lastBuildAt time.Time
+
+	shutdown shutdown
+
+	// procsMu guards Processes, once started, and credentials: both are
+	// replaced when the configuration is reloaded.
+	procsMu     sync.Mutex
+	credentials map[string]credential // map of process type name to its credential
+}
+
+// processes are the process types of the runner, as of the last reload of the
+// configuration.
+func (r *Runner) processes() []*ProcessType {
+	r.procsMu.Lock()
+	defer r.procsMu.Unlock()
+	return r.Processes
 }
 
+// DefaultBasePort is the BasePort of the runners that do not set one.
+const DefaultBasePort = 5000
+
 // New creates a new runner ready to use.
 func New() Runner {
 	return Runner{
+		BasePort:                DefaultBasePort,
 		Formation:               make(map[string]int),
 		dynamicServiceDiscovery: make(map[string]string),
 	}
 }
 
+// Validate checks the runner configuration for mistakes that would otherwise
+// silently do nothing, like formations for undeclared process types.
+func (r *Runner) Validate() error {
+	return r.validate(r.processes())
+}
+
+// validate is Validate for the process types procs, in place of Processes.
+func (r *Runner) validate(procs []*ProcessType) error {
+	declared := make(map[string]*ProcessType)
+	for _, proc := range procs {
+		declared[proc.Name] = proc
+	}
+
+	names := make([]string, 0, len(r.Formation))
+	for name := range r.Formation {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		proc, ok := declared[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("formation: unknown process type %q", name)
+		case strings.HasPrefix(proc.Name, "build"):
+			return fmt.Errorf("formation: %q is a build process type, formations do not apply to them", name)
+		case r.Formation[name] < 1:
+			return fmt.Errorf("formation: %q must have at least one instance, got %d", name, r.Formation[name])
+		}
+	}
+	if r.WatchOnly && r.watchDisabled() {
+		return errors.New("watch-only mode requires file watching, which is disabled")
+	}
+	if r.RestartBackoffMin < 0 || r.RestartBackoffMax < 0 {
+		return errors.New("restart backoff delays cannot be negative")
+	}
+	if r.WatchConfig && r.ConfigPath == "" {
+		return errors.New("watching the configuration requires its path")
+	}
+	if r.WatchConfig && r.watchDisabled() {
+		return errors.New("watching the configuration requires file watching, which is disabled")
+	}
+	if r.MuxPrefix != "" && !strings.HasPrefix(r.MuxPrefix, "/") {
+		return fmt.Errorf("mux prefix must start with /, got %q", r.MuxPrefix)
+	}
+	if err := r.validateGroupStrategies(procs); err != nil {
+		return err
+	}
+	if r.ExitWhenAllStopped {
+		for _, proc := range procs {
+			if isBuild(proc) {
+				continue
+			}
+			if proc.Restart == Always || proc.Schedule != "" {
+				return fmt.Errorf("%s: process types that restart always or run on a schedule never stop, they cannot be used with ExitWhenAllStopped", proc.Name)
+			}
+		}
+	}
+	if _, err := regexp.Compile(r.LevelPattern); err != nil {
+		return fmt.Errorf("invalid level pattern: %v", err)
+	}
+	prefixes := make(map[string]*ProcessType) // map of output label to its process type
+	for _, proc := range procs {
+		label := outputLabel(proc, -1)
+		if other, ok := prefixes[label]; ok && (other.Label != "" || proc.Label != "") {
+			return fmt.Errorf("%s and %s are both labeled %q in the output", other.Name, proc.Name, label)
+		}
+		prefixes[label] = proc
+	}
+	labels := make(map[string]string)
+	for name := range r.Labels {
+		envName := labelEnvVar(name)
+		if other, ok := labels[envName]; ok {
+			if other > name {
+				other, name = name, other
+			}
+			return fmt.Errorf("labels %q and %q are both passed as %s", other, name, envName)
+		}
+		labels[envName] = name
+	}
+	if err := validateBuildGraph(procs); err != nil {
+		return err
+	}
+	if _, err := r.forwardedSignals(); err != nil {
+		return fmt.Errorf("invalid forwarded signal: %v", err)
+	}
+	if r.Umask != nil && !validUmask(*r.Umask) {
+		return fmt.Errorf("umask %#o is out of the valid range (0-0777)", *r.Umask)
+	}
+	for _, proc := range procs {
+		if len(proc.ReloadObservables) == 0 {
+			continue
+		}
+		if _, err := parseSignal(proc.ReloadSignal); err != nil {
+			return fmt.Errorf("%s: invalid reload signal: %v", proc.Name, err)
+		}
+	}
+	for _, proc := range procs {
+		if _, err := regexp.Compile(proc.WaitForLog); err != nil {
+			return fmt.Errorf("%s: invalid log readiness expression: %v", proc.Name, err)
+		}
+		if _, err := regexp.Compile(proc.WaitCommandExpect); err != nil {
+			return fmt.Errorf("%s: invalid wait command expectation: %v", proc.Name, err)
+		}
+		if proc.WaitCommand == "" && proc.WaitCommandExpect != "" {
+			return fmt.Errorf("%s: wait command expectation without wait command", proc.Name)
+		}
+		if proc.WaitCommand != "" && proc.WaitForLog != "" {
+			return fmt.Errorf("%s: wait command and log readiness cannot be combined", proc.Name)
+		}
+		if proc.WaitCommand != "" && isBuild(proc) {
+			return fmt.Errorf("%s: wait commands do not apply to build process types", proc.Name)
+		}
+		if proc.Schedule != "" {
+			if isBuild(proc) {
+				return fmt.Errorf("%s: schedules do not apply to build process types", proc.Name)
+			}
+			if _, err := parseSchedule(proc.Schedule); err != nil {
+				return fmt.Errorf("%s: invalid schedule: %v", proc.Name, err)
+			}
+		}
+		if len(proc.Artifacts) > 0 && !isBuild(proc) {
+			return fmt.Errorf("%s: artifacts apply only to build process types", proc.Name)
+		}
+		if proc.WantPort && !isBuild(proc) {
+			return fmt.Errorf("%s: wantport applies only to build process types", proc.Name)
+		}
+		if proc.WarmRestart && isBuild(proc) {
+			return fmt.Errorf("%s: warm restarts do not apply to build process types", proc.Name)
+		}
+		if proc.Umask != nil && !validUmask(*proc.Umask) {
+			return fmt.Errorf("%s: umask %#o is out of the valid range (0-0777)", proc.Name, *proc.Umask)
+		}
+		if proc.Limits != nil {
+			if err := proc.Limits.validate(); err != nil {
+				return fmt.Errorf("%s: %v", proc.Name, err)
+			}
+		}
+		switch proc.Restart {
+		case Always, OnFailure, Temporary, Never:
+		default:
+			return fmt.Errorf("%s: unknown restart mode %q, use ParseRestartModeStrict to convert it", proc.Name, proc.Restart)
+		}
+		if proc.ReadyCommand < 0 || proc.ReadyCommand > len(proc.Cmd) {
+			return fmt.Errorf("%s: ready command %d is out of the valid range (1-%d)", proc.Name, proc.ReadyCommand, len(proc.Cmd))
+		}
+		if proc.Nice < -20 || proc.Nice > 19 {
+			return fmt.Errorf("%s: niceness %d is out of the valid range (-20-19)", proc.Name, proc.Nice)
+		}
+		for _, cpu := range proc.CPUAffinity {
+			if cpu < 0 || cpu >= maxCPU {
+				return fmt.Errorf("%s: CPU %d is out of the valid range (0-%d)", proc.Name, cpu, maxCPU-1)
+			}
+		}
+	}
+	return r.validatePlan(procs)
+}
+
+func (r *Runner) applyConcurrencyEnv() error {
+	for _, proc := range r.Processes {
+		if strings.HasPrefix(proc.Name, "build") {
+			continue
+		}
+		envVar := normalizeByEnvVarRules(proc.Name) + "_CONCURRENCY"
+		v, ok := os.LookupEnv(envVar)
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %v", envVar, err)
+		}
+		if r.Formation == nil {
+			r.Formation = make(map[string]int)
+		}
+		r.Formation[proc.Name] = count
+	}
+	return nil
+}
+
 // Start initiates the application.
 func (r *Runner) Start(rootCtx context.Context) error {
+	workDir, err := r.expandEnv(r.WorkDir)
+	if err != nil {
+		return fmt.Errorf("workdir: %v", err)
+	}
+	if workDir != r.WorkDir {
+		r.WorkDir = workDir
+	}
+	if err := r.applyConcurrencyEnv(); err != nil {
+		return err
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	if r.LevelPattern != "" {
+		r.levelRE = regexp.MustCompile(r.LevelPattern)
+	}
+
+	credentials, err := resolveCredentials(r.Processes)
+	if err != nil {
+		return err
+	}
+	r.procsMu.Lock()
+	r.credentials = credentials
+	r.procsMu.Unlock()
+
 	nameDict := make(map[string]struct{})
 	for _, proc := range r.Processes {
 		name := proc.Name
@@ -181,28 +946,84 @@ func (r *Runner) Start(rootCtx context.Context) error {
 			return ErrNonUniqueProcessTypeName
 		}
 		nameDict[normalizeByEnvVarRules(name)] = struct{}{}
+	}
+	r.warnPortsInUse()
+	if env := r.labelsEnv(); len(env) > 0 {
+		log.Println("labels:", strings.Join(env, " "))
+	}
 
-		if l := len(name); l > r.longestProcessTypeName {
-			r.longestProcessTypeName = l
+	if r.PidFile != "" {
+		pid := []byte(fmt.Sprintln(os.Getpid()))
+		if err := ioutil.WriteFile(r.PidFile, pid, 0644); err != nil {
+			return fmt.Errorf("cannot write PID file: %v", err)
 		}
+		defer os.Remove(r.PidFile)
 	}
-	r.longestProcessTypeName++
 
-	go r.serveServiceDiscovery(rootCtx)
+	if err := r.serveServiceDiscovery(rootCtx); err != nil {
+		return err
+	}
 
-	updates, err := r.monitorWorkDir(rootCtx)
-	if err != nil {
+	if err := r.forwardSignals(rootCtx); err != nil {
+		return err
+	}
+
+	var updates <-chan string
+	if r.watchDisabled() {
+		log.Println("file watching disabled")
+		initial := make(chan string, 1)
+		initial <- ""
+		updates = initial
+	} else if updates, err = r.monitorWorkDir(rootCtx); err != nil {
 		return err
 	}
+	if r.WatchOnly {
+		log.Println("watch-only mode, no process type is started")
+		<-rootCtx.Done()
+		return nil
+	}
+	updates = r.gateUpdates(rootCtx, updates)
 
 	run := make(chan string)
 	fileHashes := make(map[string]string) // fn to hash
 	c, cancel := context.WithCancel(rootCtx)
+	if r.LogFlushInterval > 0 {
+		r.out.flushEvery(rootCtx, r.clock(), r.LogFlushInterval)
+	}
+	r.fatal = make(chan error, 1)
+	r.allStopped = make(chan error, 1)
+	var (
+		runningGenCtx, pendingGenCtx   context.Context = rootCtx, nil
+		runningGenSpan, pendingGenSpan Span            = noopSpan{}, nil
+	)
+	stop := func(err error) error {
+		r.shutdown.stop()
+		r.out.stopBuffering()
+		if err != nil {
+			runningGenSpan.RecordError(err)
+		}
+		runningGenSpan.End()
+		if pendingGenSpan != nil {
+			pendingGenSpan.End()
+		}
+		if r.Summary {
+			r.writeSummary(r.output())
+		}
+		return err
+	}
 	for {
 		select {
 		case <-rootCtx.Done():
 			cancel()
-			return nil
+			return stop(nil)
+		case err := <-r.fatal:
+			log.Println(err)
+			cancel()
+			return stop(err)
+		case err := <-r.allStopped:
+			log.Println("all processes stopped")
+			cancel()
+			return stop(err)
 		case fn := <-updates:
 			newHash := calcFileHash(fn)
 			oldHash, ok := fileHashes[fn]
@@ -212,20 +1033,57 @@ func (r *Runner) Start(rootCtx context.Context) error {
 			}
 			fileHashes[fn] = newHash
 
-			if ok := r.runBuilds(c, fn); !ok {
+			if r.isConfigFile(fn) {
+				if err := r.reloadConfig(); err != nil {
+					log.Println("cannot reload the configuration, keeping the current one:", err)
+					continue
+				}
+				log.Println("reloaded the configuration from", fn)
+			}
+
+			if r.reload(fn) {
+				continue
+			}
+
+			if pendingGenSpan == nil {
+				pendingGenCtx, pendingGenSpan = r.tracer().Start(rootCtx, "generation")
+				runID := newRunID()
+				pendingGenCtx = withTraceParent(withRunID(pendingGenCtx, runID))
+				log.Println("preparing run", runID)
+			}
+			if r.SkipBuilds {
+				log.Println("skipping builds")
+			} else if ok := r.runBuilds(withValues(c, pendingGenCtx), fn); !ok {
 				log.Println("error during build, halted")
 				continue
 			}
 
 			if l := len(updates); l == 0 {
 				cancel()
-				go func() { run <- fn }()
+				runningGenSpan.End()
+				runningGenCtx, runningGenSpan = pendingGenCtx, pendingGenSpan
+				pendingGenCtx, pendingGenSpan = nil, nil
+				delay := r.PostBuildDelay
+				if r.SkipBuilds {
+					delay = 0
+				}
+				go func() {
+					select {
+					case <-r.clock().After(delay):
+					case <-rootCtx.Done():
+						return
+					}
+					select {
+					case run <- fn:
+					case <-rootCtx.Done():
+					}
+				}()
 			} else {
 				log.Println("builds pending before application start:", l)
 			}
 		case fn := <-run:
 			c, cancel = context.WithCancel(rootCtx)
-			go r.runNonBuilds(rootCtx, c, fn)
+			go r.runNonBuilds(rootCtx, withValues(c, runningGenCtx), fn)
 		}
 	}
 }
@@ -246,12 +1104,27 @@ func calcFileHash(fn string) string {
 }
 
 func (r *Runner) runBuilds(ctx context.Context, fn string) bool {
+	r.buildMu.Lock()
+	defer r.buildMu.Unlock()
+	ctx, span := r.tracer().Start(ctx, "builds")
+	defer span.End()
 	var (
 		wgBuild sync.WaitGroup
 		mu      sync.Mutex
 		ok      = true
+		slots   chan struct{}
+		done    = make(map[string]chan struct{}) // closed once the build finishes
+		failed  = make(map[string]bool)
 	)
-	for _, sv := range r.Processes {
+	if r.MaxBuildParallelism > 0 {
+		slots = make(chan struct{}, r.MaxBuildParallelism)
+	}
+	for _, sv := range r.processes() {
+		if strings.HasPrefix(sv.Name, "build") {
+			done[sv.Name] = make(chan struct{})
+		}
+	}
+	for _, sv := range r.processes() {
 		if !strings.HasPrefix(sv.Name, "build") {
 			continue
 		}
@@ -259,42 +1132,114 @@ func (r *Runner) runBuilds(ctx context.Context, fn string) bool {
 		wgBuild.Add(1)
 		go func(sv *ProcessType) {
 			defer wgBuild.Done()
+			finished := done[sv.Name]
+			defer close(finished)
 			defer func() {
 				r.setServiceDiscovery(normalizeByEnvVarRules(sv.Name), "done")
 			}()
+			fail := func() {
+				mu.Lock()
+				ok = false
+				failed[sv.Name] = true
+				mu.Unlock()
+			}
+			for _, dep := range sv.DependsOn {
+				depDone, declared := done[dep]
+				if !declared {
+					continue
+				}
+				select {
+				case <-depDone:
+				case <-ctx.Done():
+					fail()
+					return
+				}
+				mu.Lock()
+				depFailed := failed[dep]
+				mu.Unlock()
+				if depFailed {
+					log.Println(sv.Name, "skipped, dependency", dep, "failed")
+					fail()
+					return
+				}
+			}
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					fail()
+					return
+				}
+			}
 			c := ctx
 			if sv.Sticky {
 				log.Println(sv.Name, "is sticky")
-				c = context.Background()
+				c = withValues(context.Background(), ctx)
 			}
-			if !r.startProcess(c, sv, -1, -1, fn) {
-				mu.Lock()
-				ok = false
-				mu.Unlock()
+			r.reportProgress(ProgressEvent{Type: ProgressBuildStarted, Name: sv.Name})
+			err := r.startProcess(c, sv, -1, r.buildPortCount(sv), fn)
+			if err == nil {
+				err = r.checkArtifacts(sv)
+				if err != nil {
+					log.Printf("%s: %v", sv.Name, err)
+				}
+			}
+			r.reportBuildFinished(sv.Name, err == nil)
+			if err != nil {
+				fail()
 			}
 		}(sv)
 	}
 	wgBuild.Wait()
+	r.collectBuildEnv()
+	r.recordBuild(ok)
 	return ok
 }
 
+// watchDisabled tells whether the WorkDir is not monitored, see DisableWatch.
+func (r *Runner) watchDisabled() bool {
+	return r.DisableWatch || envFlag("RUNNER_NO_WATCH")
+}
+
+// checkArtifacts tells whether the build process type sv produced all its
+// Artifacts.
+func (r *Runner) checkArtifacts(sv *ProcessType) error {
+	for _, fn := range sv.Artifacts {
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(r.WorkDir, fn)
+		}
+		fi, err := os.Stat(fn)
+		switch {
+		case err != nil:
+			return fmt.Errorf("missing artifact: %v", err)
+		case fi.Size() == 0:
+			return fmt.Errorf("empty artifact: %s", fn)
+		}
+	}
+	return nil
+}
+
 func (r *Runner) runNonBuilds(rootCtx, ctx context.Context, changedFileName string) {
 	ctx = supervisor.WithContext(ctx)
 	groups := make(map[string]context.Context)
 	ready := make(chan struct{})
 
-	for j, sv := range r.Processes {
-		if strings.HasPrefix(sv.Name, "build") {
-			continue
-		}
-
-		maxProc := 1
-		if formation, ok := r.Formation[sv.Name]; ok {
-			maxProc = formation
-		}
+	var (
+		staticServiceDiscovery []string
+		expected               []string
+		stops                  *stopTracker
+	)
+	r.sdMu.Lock()
+	generation := r.currentGeneration
+	r.sdMu.Unlock()
+	for _, inst := range r.plan() {
+		sv, i, pc := inst.proc, inst.instance, inst.port-r.basePort()
 
 		procCtx := ctx
-		if sv.Group != "" {
+		if r.groupStrategy(sv.Group) == OneForOne {
+			procCtx = supervisor.WithContext(ctx)
+		} else if sv.Group != "" {
 			groupCtx, ok := groups[sv.Group]
 			if !ok {
 				groupCtx = supervisor.WithContext(ctx)
@@ -303,50 +1248,138 @@ func (r *Runner) runNonBuilds(rootCtx, ctx context.Context, changedFileName stri
 			procCtx = groupCtx
 		}
 
-		portCount := j * 100
-		for i := 0; i < maxProc; i++ {
-			sv, i, pc := sv, i, portCount
-
-			if sv.Restart == Temporary && r.currentGeneration == 0 {
-				temporarySvcCtx := supervisor.WithContext(rootCtx)
-				supervisor.Add(temporarySvcCtx, func(ctx context.Context) {
-					<-ready
-					r.startProcess(ctx, sv, i, pc, changedFileName)
-				}, supervisor.Temporary)
-				portCount++
-			} else if sv.Restart == Temporary && r.currentGeneration != 0 {
-				portCount++
+		if sv.Schedule != "" {
+			sched, err := parseSchedule(sv.Schedule)
+			if err != nil {
+				log.Println(inst.name, "has an invalid schedule:", err)
 				continue
-			} else {
-				opt := supervisor.Temporary
-				switch sv.Restart {
-				case Always:
-					opt = supervisor.Permanent
-				case OnFailure:
-					opt = supervisor.Transient
+			}
+			supervisor.Add(procCtx, func(ctx context.Context) {
+				<-ready
+				r.runScheduled(ctx, sched, sv, i, pc, changedFileName)
+			}, supervisor.Temporary)
+			staticServiceDiscovery = append(
+				staticServiceDiscovery,
+				fmt.Sprintf("%s=localhost:%d", discoveryEnvVar(sv.Name, i), inst.port),
+			)
+			continue
+		}
+
+		if sv.Restart == Temporary && generation == 0 {
+			expected = append(expected, inst.name)
+			temporarySvcCtx := supervisor.WithContext(withValues(rootCtx, ctx))
+			procName := inst.name
+			supervisor.Add(temporarySvcCtx, func(ctx context.Context) {
+				<-ready
+				err := r.startProcess(ctx, sv, i, pc, changedFileName)
+				if ctx.Err() == nil {
+					stops.stopped(procName, err)
+					r.stopDependents(procName)
 				}
-				supervisor.Add(procCtx, func(ctx context.Context) {
-					<-ready
-					ok := r.startProcess(ctx, sv, i, pc, changedFileName)
-					if !ok && sv.Restart == OnFailure {
-						panic("restarting on failure")
-					}
-				}, opt)
-				r.staticServiceDiscovery = append(
-					r.staticServiceDiscovery,
-					fmt.Sprintf("%s=localhost:%d", discoveryEnvVar(sv.Name, i), r.BasePort+portCount),
-				)
-				portCount++
+			}, supervisor.Temporary)
+		} else if sv.Restart == Temporary && generation != 0 {
+			continue
+		} else {
+			expected = append(expected, inst.name)
+			opt, permanent := supervisor.Temporary, false
+			switch {
+			case len(sv.RestartExitCodes) > 0:
+				opt = supervisor.Transient
+			case sv.Restart == Always:
+				opt, permanent = supervisor.Permanent, true
+			case sv.Restart == OnFailure, sv.LivenessProbe != nil, sv.StartupProbe != nil:
+				opt = supervisor.Transient
 			}
+			procName := inst.name
+			var restarting, recycled, gaveUp bool
+			supervisor.Add(procCtx, func(ctx context.Context) {
+				<-ready
+				if gaveUp {
+					// restarted along with the rest of its group.
+					<-ctx.Done()
+					return
+				}
+				if restarting && !r.waitRestartRate(ctx) {
+					return
+				}
+				if !restarting {
+					r.resetRestarts(procName)
+				} else if recycled {
+					r.setState(sv, i, Restarting)
+				} else if !r.allowRestart(procName, sv) {
+					gaveUp = true
+					log.Println("giving up on", procName+", restarted too many times")
+					stops.stopped(procName, errors.New("restarted too many times"))
+					r.stopDependents(procName)
+					<-ctx.Done()
+					return
+				} else {
+					r.setState(sv, i, Restarting)
+				}
+				exited := restarting && !recycled
+				restarting = true
+				if delay := r.restartBackoff(procName, sv, exited); delay > 0 {
+					log.Println(procName, "exited, restarting in", delay)
+					select {
+					case <-r.clock().After(delay):
+					case <-ctx.Done():
+						return
+					}
+				}
+				startedAt := r.clock().Now()
+				err := r.startProcess(ctx, sv, i, pc, changedFileName)
+				recycled = err == errMaxRuntimeReached
+				if ctx.Err() == nil && !recycled {
+					r.recordUptime(procName, sv, r.since(startedAt))
+				}
+				switch {
+				case recycled:
+					// not a failure, restarted only if Restart is Always.
+				case err == errLivenessProbeFailed:
+					panic("restarting on liveness probe failure")
+				case len(sv.RestartExitCodes) > 0:
+					if ctx.Err() == nil && containsInt(sv.RestartExitCodes, exitCode(err)) {
+						panic(fmt.Sprint("restarting on exit code ", exitCode(err)))
+					}
+				case err != nil && sv.Restart == OnFailure:
+					panic("restarting on failure")
+				}
+				if ctx.Err() == nil {
+					if recycled {
+						err = nil
+					}
+					stops.stopped(procName, err)
+					if !permanent {
+						r.stopDependents(procName)
+					}
+				}
+			}, opt)
+			staticServiceDiscovery = append(
+				staticServiceDiscovery,
+				fmt.Sprintf("%s=localhost:%d", discoveryEnvVar(sv.Name, i), inst.port),
+			)
 		}
 	}
+	r.sdMu.Lock()
+	r.staticServiceDiscovery = staticServiceDiscovery
 	r.currentGeneration++
+	r.sdMu.Unlock()
+	r.readiness.expect(expected)
+	if r.WaitTimeout > 0 {
+		go r.watchReadiness(ctx, r.fatal)
+	}
+	if r.ExitWhenAllStopped {
+		stops = newStopTracker(len(expected), r.allStopped)
+	}
 	close(ready)
 
 	<-ctx.Done()
 }
 
 func discoveryEnvVar(name string, procCount int) string {
+	if procCount < 0 {
+		return normalizeByEnvVarRules(name + "_PORT")
+	}
 	return normalizeByEnvVarRules(fmt.Sprintf("%s_%d_PORT", name, procCount))
 }
 
@@ -368,87 +1401,332 @@ func normalizeByEnvVarRules(name string) string {
 	return strings.ToUpper(buf.String())
 }
 
-func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, portCount int, changedFileName string) bool {
-	pr, pw := io.Pipe()
+func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, portCount int, changedFileName string) (err error) {
+	release, ok := r.shutdown.track()
+	if !ok {
+		return context.Canceled
+	}
+	defer release()
+
 	procName := sv.Name
-	port := r.BasePort + portCount
+	port := r.basePort() + portCount
 	if procCount > -1 {
 		procName = fmt.Sprintf("%v.%v", procName, procCount)
 	}
 	if portCount > -1 {
 		r.setServiceDiscovery(discoveryEnvVar(sv.Name, procCount), fmt.Sprint("localhost:", port))
 	}
-	r.prefixedPrinter(ctx, pr, procName)
+	label := outputLabel(sv, procCount)
+	pr, pw := io.Pipe()
+	r.prefixedPrinter(ctx, pr, sv, procName, label, r.metaOutput(), nil)
 
 	defer pw.Close()
 	defer pr.Close()
 
+	var warm *warmRun
+	if sv.WarmRestart && procCount > -1 {
+		warm = r.warmRuns.begin(procName)
+	}
+
+	r.setState(sv, procCount, Starting)
+	defer func() {
+		if !r.warmRuns.superseded(procName, warm) {
+			r.setState(sv, procCount, Exited)
+		}
+	}()
+	if procCount > -1 {
+		defer func() {
+			if !r.warmRuns.superseded(procName, warm) {
+				r.readiness.markStopped(procName)
+			}
+		}()
+	}
+
+	envFiles, err := r.loadProcessEnvFiles(sv)
+	if err != nil {
+		fmt.Fprintln(pw, "cannot load environment files:", err)
+		return err
+	}
+
+	secrets, err := r.loadSecrets(ctx, sv)
+	if err != nil {
+		fmt.Fprintln(pw, "cannot load secrets:", err)
+		return err
+	}
+	r.secrets.set(procName, secrets)
+
+	envOut, err := r.prepareBuildEnvOut(sv)
+	if err != nil {
+		fmt.Fprintln(pw, "cannot prepare build environment output:", err)
+		return err
+	}
+	if envOut != "" {
+		defer os.Remove(envOut)
+	}
+
+	r.recordStart(procName)
+	lastExitCode := 0
+	defer func() {
+		if !r.warmRuns.superseded(procName, warm) {
+			r.recordExit(procName, lastExitCode)
+		}
+		if procCount > -1 {
+			r.reportExited(procName, lastExitCode)
+		}
+	}()
+
+	ctx, span := r.tracer().Start(ctx, "process "+procName)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	runtimeCtx, maxRuntimeReached, stopRuntime := r.limitRuntime(ctx, pw, sv)
+	defer stopRuntime()
+
+	var stepSpan Span = noopSpan{}
+	defer func() { stepSpan.End() }()
 	for idx, cmd := range sv.Cmd {
-		fmt.Fprintln(pw, "running", `"`+cmd+`"`)
+		banner := "running"
+		if isBuild(sv) && len(sv.Cmd) > 1 {
+			step := fmt.Sprintf("[%d/%d]", idx+1, len(sv.Cmd))
+			stepSpan.End()
+			_, stepSpan = r.tracer().Start(ctx, "step "+step+" "+cmd)
+			banner = step + " running"
+		}
+		if !sv.NoBanner {
+			fmt.Fprintln(pw, banner, `"`+cmd+`"`)
+			if portCount > -1 {
+				fmt.Fprintln(pw, "listening on", port)
+			}
+			fmt.Fprintln(pw)
+		}
 		defer fmt.Fprintln(pw, "finished", `"`+cmd+`"`)
-		if portCount > -1 {
-			fmt.Fprintln(pw, "listening on", port)
+		cmdCtx, cancelCmd := context.WithCancel(runtimeCtx)
+		defer cancelCmd()
+		c := r.command(cmdCtx, r.shellCommand(sv, cmd))
+		if c == nil {
+			fmt.Fprintln(pw, "the command factory returned no command for", `"`+cmd+`"`)
+			return errors.New("no command")
+		}
+		if c.Dir == "" {
+			c.Dir = r.WorkDir
+		}
+		setProcessGroup(c)
+		if cred, ok := r.credential(sv.Name); ok {
+			if err := setCredential(c, cred); err != nil {
+				fmt.Fprintln(pw, "cannot run as", sv.User+":", err)
+				return err
+			}
 		}
-		fmt.Fprintln(pw)
-		c := exec.CommandContext(ctx, "sh", "-c", cmd)
-		c.Dir = r.WorkDir
 
-		c.Env = os.Environ()
-		if len(r.BaseEnvironment) > 0 {
-			c.Env = r.BaseEnvironment
+		c.Env = append(os.Environ(), r.baseEnvironment()...)
+		c.Env = append(c.Env, envFiles...)
+		c.Env = append(c.Env, sv.Env...)
+		c.Env = append(c.Env, secrets...)
+		if isBuild(sv) {
+			c.Env = append(c.Env, fmt.Sprintf("RUNNER_ENV_OUT=%v", envOut))
+		} else {
+			c.Env = append(c.Env, r.buildExportedEnv()...)
+		}
+		if len(sv.PathPrepend) > 0 {
+			c.Env = append(c.Env, r.prependPath(c.Env, sv.PathPrepend))
 		}
+		c.Env = append(c.Env, r.labelsEnv()...)
 		c.Env = append(c.Env, fmt.Sprintf("PS=%v", procName))
+		if runID := runIDFrom(ctx); runID != "" {
+			c.Env = append(c.Env, fmt.Sprintf("RUN_ID=%v", runID))
+		}
+		if traceParent := traceParentFrom(ctx); traceParent != "" {
+			c.Env = append(c.Env, fmt.Sprintf("TRACEPARENT=%v", traceParent))
+		}
 		if portCount > -1 {
 			c.Env = append(c.Env, fmt.Sprintf("PORT=%d", port))
 		}
+		if warm != nil {
+			c.Env = append(c.Env, "SO_REUSEPORT=1")
+		}
 
 		if r.ServiceDiscoveryAddr != "" {
-			c.Env = append(c.Env, fmt.Sprintf("DISCOVERY=%v", r.ServiceDiscoveryAddr))
-			c.Env = append(c.Env, r.staticServiceDiscovery...)
+			c.Env = append(c.Env, fmt.Sprintf("DISCOVERY=%v", r.discoveryEnv()))
+			c.Env = append(c.Env, r.serviceDiscoveryEnv()...)
 		}
 
 		c.Env = append(c.Env, fmt.Sprintf("CHANGED_FILENAME=%v", changedFileName))
+		c.Env = mergeEnv(c.Env)
 
-		stderrPipe, err := c.StderrPipe()
-		if err != nil {
-			fmt.Fprintln(pw, "cannot open stderr pipe", procName, cmd)
-			continue
+		isFirstCommand := idx == 0
+		isLastCommand := idx+1 == len(sv.Cmd)
+		isReadyCommand := isLastCommand
+		if sv.ReadyCommand > 0 {
+			isReadyCommand = idx+1 == sv.ReadyCommand
+		}
+		waitTarget := ""
+		if isFirstCommand && sv.WaitBefore != "" {
+			waitTarget = sv.WaitBefore
+		} else if isLastCommand && sv.WaitFor != "" {
+			waitTarget = sv.WaitFor
+		}
+		if waitTarget != "" {
+			target, err := r.expandEnv(waitTarget)
+			if err != nil {
+				fmt.Fprintln(pw, "cannot expand wait target:", err)
+				return err
+			}
+			r.waitFor(runtimeCtx, pw, target)
 		}
-		stdoutPipe, err := c.StdoutPipe()
+		if isLastCommand && sv.WaitForFile != "" {
+			fn, err := r.expandEnv(sv.WaitForFile)
+			if err != nil {
+				fmt.Fprintln(pw, "cannot expand wait file:", err)
+				return err
+			}
+			r.waitForFile(runtimeCtx, pw, fn, sv.WaitForFileNotEmpty)
+		}
+
+		if maxRuntimeReached() {
+			return errMaxRuntimeReached
+		}
+		if cmdCtx.Err() != nil || r.shutdown.isStopping() {
+			lastExitCode = ExitTerminated
+			return context.Canceled
+		}
+
+		if sv.StdinFile != "" {
+			stdin, err := r.openStdinFile(sv)
+			if err != nil {
+				fmt.Fprintln(pw, "cannot open stdin file:", err)
+				return err
+			}
+			defer stdin.Close()
+			c.Stdin = stdin
+		}
+
+		// The pipe is created here, rather than with StderrPipe and
+		// StdoutPipe, so Wait does not close it while the output of
+		// commands that exit right away is still being read. Both
+		// outputs share it, so each command needs a single reader.
+		outputPipe, outputW, err := os.Pipe()
 		if err != nil {
-			fmt.Fprintln(pw, "cannot open stdout pipe", procName, cmd)
+			fmt.Fprintln(pw, "cannot open output pipe", procName, cmd)
 			continue
 		}
+		c.Stderr, c.Stdout = outputW, outputW
 
-		r.prefixedPrinter(ctx, stderrPipe, procName)
-		r.prefixedPrinter(ctx, stdoutPipe, procName)
-
-		isFirstCommand := idx == 0
-		isLastCommand := idx+1 == len(sv.Cmd)
-		if isFirstCommand && sv.WaitBefore != "" {
-			r.waitFor(ctx, pw, sv.WaitBefore)
-		} else if isLastCommand && sv.WaitFor != "" {
-			r.waitFor(ctx, pw, sv.WaitFor)
+		var onLine func(string)
+		if isReadyCommand && procCount > -1 && sv.WaitForLog != "" {
+			onLine = r.readyOnLogLine(pw, sv, procCount, warm)
+		}
+		firstOutput := make(chan struct{})
+		if sv.StartTimeout > 0 {
+			var once sync.Once
+			next := onLine
+			onLine = func(line string) {
+				once.Do(func() { close(firstOutput) })
+				if next != nil {
+					next(line)
+				}
+			}
 		}
+		r.prefixedPrinter(ctx, outputPipe, sv, procName, label, r.output(), onLine)
 
-		if err := c.Run(); err != nil {
+		if isReadyCommand {
+			r.setState(sv, procCount, Running)
+		}
+		err = c.Start()
+		outputW.Close()
+		if err != nil {
 			fmt.Fprintf(pw, "exec error %s: (%s) %v\n", procName, cmd, err)
-			return false
+			lastExitCode = exitCode(err)
+			return err
+		}
+		stopCtx, releaseHandoff := r.warmHandoff(ctx, cmdCtx, pw, procName, warm)
+		exited := r.terminateOnCancel(stopCtx, c.Process)
+		startTimedOut, stopStartTimer := r.limitStart(pw, sv, firstOutput, cancelCmd)
+		if sv.Nice != 0 {
+			if err := setNice(c.Process.Pid, sv.Nice); err != nil {
+				fmt.Fprintln(pw, "cannot set niceness:", err)
+			}
+		}
+		if isLastCommand && len(sv.CPUAffinity) > 0 {
+			if err := setCPUAffinity(c.Process.Pid, sv.CPUAffinity); err != nil {
+				fmt.Fprintln(pw, "cannot set CPU affinity:", err)
+			}
+		}
+		var probeFailed <-chan struct{}
+		if isLastCommand && (sv.LivenessProbe != nil || sv.StartupProbe != nil) {
+			probeFailed = r.probeHealth(cmdCtx, pw, sv, cancelCmd)
+		}
+		if isLastCommand && procCount > -1 {
+			r.setLiveProcess(procName, c.Process)
+		}
+		stopWaitCommand := func() {}
+		if isReadyCommand && procCount > -1 && sv.WaitCommand != "" {
+			stopWaitCommand = r.readyOnCommand(cmdCtx, pw, sv, procCount, warm, c.Env)
+		} else if isReadyCommand && procCount > -1 && sv.WaitForLog == "" {
+			r.markReady(sv, procCount, warm)
+		}
+		err = c.Wait()
+		stopWaitCommand()
+		stopped := exited()
+		releaseHandoff()
+		stopStartTimer()
+		if isLastCommand && procCount > -1 && !r.warmRuns.superseded(procName, warm) {
+			r.setLiveProcess(procName, nil)
+		}
+		lastExitCode = exitCode(err)
+		if err != nil && (stopped || cmdCtx.Err() != nil) {
+			lastExitCode = ExitTerminated
+		}
+		if maxRuntimeReached() {
+			return errMaxRuntimeReached
+		}
+		if startTimedOut() {
+			return errStartTimeout
+		}
+		if err != nil {
+			select {
+			case <-probeFailed:
+				fmt.Fprintln(pw, "health probe failed, restarting")
+				return errLivenessProbeFailed
+			default:
+			}
+			fmt.Fprintf(pw, "exec error %s: (%s) %v\n", procName, cmd, err)
+			return err
+		}
+	}
+	if envOut != "" {
+		if err := r.storeBuildEnv(sv, envOut); err != nil {
+			fmt.Fprintln(pw, "cannot read build environment output:", err)
+			return err
 		}
 	}
-	return true
+	return nil
 }
 
 func (r *Runner) waitFor(ctx context.Context, w io.Writer, target string) {
+	_, span := r.tracer().Start(ctx, "waitfor "+target)
+	defer span.End()
 	fmt.Fprintln(w, "waiting for", target)
 	defer fmt.Fprintln(w, "starting")
+	stopping, _ := r.shutdown.channels()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(250 * time.Millisecond):
+		case <-stopping:
+			return
+		case <-r.clock().After(250 * time.Millisecond):
+			if inst, ok := r.logReadinessTarget(target); ok {
+				if r.readiness.isReady(inst) {
+					return
+				}
+				continue
+			}
 			target = r.resolveProcessTypeAddress(target)
-			c, err := net.Dial("tcp", target)
+			c, err := r.dial(ctx, target)
 			if err == nil {
 				c.Close()
 				return
@@ -457,25 +1735,84 @@ func (r *Runner) waitFor(ctx context.Context, w io.Writer, target string) {
 	}
 }
 
+// dial opens a TCP connection to addr with the DialContext of the runner.
+func (r *Runner) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if r.DialContext != nil {
+		return r.DialContext(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// waitForFile polls for the file fn to exist, and to have some content if
+// notEmpty is set.
+func (r *Runner) waitForFile(ctx context.Context, w io.Writer, fn string, notEmpty bool) {
+	_, span := r.tracer().Start(ctx, "waitforfile "+fn)
+	defer span.End()
+	if !filepath.IsAbs(fn) {
+		fn = filepath.Join(r.WorkDir, fn)
+	}
+	fmt.Fprintln(w, "waiting for file", fn)
+	defer fmt.Fprintln(w, "starting")
+	stopping, _ := r.shutdown.channels()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopping:
+			return
+		case <-r.clock().After(250 * time.Millisecond):
+			fi, err := os.Stat(fn)
+			if err == nil && (!notEmpty || fi.Size() > 0) {
+				return
+			}
+		}
+	}
+}
+
+// resolveProcessTypeAddress translates process type names into their network
+// addresses. A process type name (e.g. "web") resolves to its first instance,
+// and a instance name (e.g. "web.1") resolves to that specific instance. Any
+// other target is returned unchanged.
 func (r *Runner) resolveProcessTypeAddress(target string) string {
 	r.sdMu.Lock()
 	defer r.sdMu.Unlock()
 
-	for name, port := range r.dynamicServiceDiscovery {
-		if strings.HasPrefix(name, target) {
-			return fmt.Sprint("localhost:", port)
+	candidates := []string{
+		normalizeByEnvVarRules(target + "_PORT"),
+		discoveryEnvVar(target, 0),
+	}
+	for _, name := range candidates {
+		if addr, ok := r.dynamicServiceDiscovery[name]; ok {
+			return addr
 		}
 	}
 	return target
 }
 
-func (r *Runner) prefixedPrinter(ctx context.Context, rdr io.Reader, name string) *bufio.Scanner {
-	paddedName := (name + strings.Repeat(" ", r.longestProcessTypeName))[:r.longestProcessTypeName]
+func (r *Runner) serviceDiscoveryEnv() []string {
+	r.sdMu.Lock()
+	defer r.sdMu.Unlock()
+	return r.staticServiceDiscovery
+}
+
+// prefixedPrinter prints the lines read from rdr, in the background, until it
+// is exhausted.
+func (r *Runner) prefixedPrinter(ctx context.Context, rdr io.Reader, sv *ProcessType, name, label string, w io.Writer, onLine func(string)) {
+	print := r.linePrinter(sv, name, label, w)
 	scanner := bufio.NewScanner(rdr)
-	scanner.Buffer(make([]byte, 65536), 2*1048576)
+	buf := scanBuffers.Get().(*[]byte)
+	scanner.Buffer(*buf, maxLineLength)
 	go func() {
+		defer scanBuffers.Put(buf)
+		if closer, ok := rdr.(io.Closer); ok {
+			defer closer.Close()
+		}
 		for scanner.Scan() {
-			fmt.Println(paddedName+":", scanner.Text())
+			if onLine != nil {
+				onLine(scanner.Text())
+			}
+			print(scanner.Text())
 		}
 
 		select {
@@ -485,11 +1822,31 @@ func (r *Runner) prefixedPrinter(ctx context.Context, rdr io.Reader, name string
 			return
 		default:
 			if err := scanner.Err(); err != nil && err != os.ErrClosed && err != io.ErrClosedPipe {
-				fmt.Println(paddedName+":", "error:", err)
+				print("error: " + err.Error())
 			}
 		}
 	}()
-	return scanner
+}
+
+// linePrinter prints the lines of name, an instance of sv, to w, prefixed by
+// label.
+func (r *Runner) linePrinter(sv *ProcessType, name, label string, w io.Writer) func(line string) {
+	width := r.prefixWidth()
+	paddedName := (label + strings.Repeat(" ", width))[:width]
+	colored := colorEnabled(w)
+	if colored {
+		paddedName = colorize(sv.Name, paddedName)
+	}
+	return func(line string) {
+		line = truncateLine(r.secrets.redact(name, line), r.TruncateLines)
+		level := parseLevel(r.levelRE, line)
+		if colored && r.ColorLevels {
+			r.out.writeLine(w, paddedName+":", colorizeLevel(level, line))
+		} else {
+			r.out.writeLine(w, paddedName+":", line)
+		}
+		r.logs.publish(name, level, line)
+	}
 }
 
 func (r *Runner) setServiceDiscovery(svc, state string) {
@@ -497,3 +1854,17 @@ func (r *Runner) setServiceDiscovery(svc, state string) {
 	r.dynamicServiceDiscovery[svc] = state
 	r.sdMu.Unlock()
 }
+
+const truncatedMark = "…(truncated)"
+
+// truncateLine cuts lines longer than n bytes, without breaking UTF-8
+// sequences apart.
+func truncateLine(line string, n int) string {
+	if n <= 0 || len(line) <= n {
+		return line
+	}
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+	return line[:n] + truncatedMark
+}
@@ -18,6 +18,8 @@ package runner
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -33,6 +35,8 @@ func (s *Runner) monitorWorkDir(ctx context.Context) (<-chan string, error) {
 	}
 
 	memo := make(map[string]struct{})
+	var skipped []string         // skipped directories
+	watched := map[string]bool{} // observables matching watched files
 	err = filepath.Walk(s.WorkDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -43,12 +47,21 @@ func (s *Runner) monitorWorkDir(ctx context.Context) (<-chan string, error) {
 					continue
 				}
 				if strings.HasPrefix(path, filepath.Join(s.WorkDir, skipDir)) {
+					skipped = append(skipped, path)
 					return filepath.SkipDir
 				}
 			}
 			return nil
 		}
+		if matchAny(s.SkipFiles, path) {
+			return nil
+		}
 		for _, p := range s.Observables {
+			if match(p, path) {
+				watched[p] = true
+			}
+		}
+		for _, p := range s.watchPatterns() {
 			if match(p, path) {
 				dir := filepath.Dir(path)
 				if _, ok := memo[dir]; !ok {
@@ -63,13 +76,52 @@ func (s *Runner) monitorWorkDir(ctx context.Context) (<-chan string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if s.WatchConfig {
+		dir := filepath.Dir(s.ConfigPath)
+		if _, ok := memo[dir]; !ok {
+			memo[dir] = struct{}{}
+			if err := watcher.Add(dir); err != nil {
+				return nil, fmt.Errorf("cannot watch the configuration: %v", err)
+			}
+		}
+	}
 	log.Println("monitoring", len(memo), "directories")
+	s.warnSkippedObservables(watched, skipped)
 
 	triggereds := s.consumeFsnotifyEvents(ctx, watcher)
 	go func() { triggereds <- "" }()
 	return triggereds, nil
 }
 
+// warnSkippedObservables warns about the observables that match no watched
+// file, but match files in the skipped directories: changes to them never
+// trigger restarts, which is likely a misconfiguration.
+func (s *Runner) warnSkippedObservables(watched map[string]bool, skipped []string) {
+	for _, p := range s.Observables {
+		if watched[p] {
+			continue
+		}
+		for _, dir := range skipped {
+			if walkFinds(dir, func(path string) bool { return match(p, path) }) {
+				log.Printf("warning: %q only matches files in skipped directories (such as %s), they are not watched", p, dir)
+				break
+			}
+		}
+	}
+}
+
+// walkFinds reports whether any file under root satisfies found.
+func walkFinds(root string, found func(path string) bool) bool {
+	errFound := errors.New("found")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && found(path) {
+			return errFound
+		}
+		return nil
+	})
+	return err == errFound
+}
+
 func (s *Runner) consumeFsnotifyEvents(ctx context.Context, watcher *fsnotify.Watcher) chan string {
 	triggereds := make(chan string, 1024)
 
@@ -80,12 +132,24 @@ func (s *Runner) consumeFsnotifyEvents(ctx context.Context, watcher *fsnotify.Wa
 			case <-ctx.Done():
 				return
 			case event := <-watcher.Events:
+				if matchAny(s.SkipFiles, event.Name) {
+					continue
+				}
+				if s.WatchOnly {
+					s.reportChange(event)
+					continue
+				}
 				if event.Op&fsnotify.Write != fsnotify.Write {
 					continue
 				}
-				for _, p := range s.Observables {
+				if s.isConfigFile(event.Name) {
+					triggereds <- event.Name
+					continue
+				}
+				for _, p := range s.watchPatterns() {
 					if match(p, event.Name) {
 						triggereds <- event.Name
+						break
 					}
 				}
 			case err := <-watcher.Errors:
@@ -96,3 +160,12 @@ func (s *Runner) consumeFsnotifyEvents(ctx context.Context, watcher *fsnotify.Wa
 
 	return triggereds
 }
+
+func (s *Runner) reportChange(event fsnotify.Event) {
+	for _, p := range s.watchPatterns() {
+		if match(p, event.Name) {
+			fmt.Fprintln(s.metaOutput(), "detected", strings.ToLower(event.Op.String()), event.Name)
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !poll
+
+
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarnSkippedObservables(t *testing.T) {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Observables = []string{"*.go", "*.proto"}
+	r.SkipDirs = []string{"vendor"}
+	for _, fn := range []string{"main.go", "vendor/api/api.proto"} {
+		fn = filepath.Join(r.WorkDir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := r.monitorWorkDir(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	t.Log(out)
+	if !strings.Contains(out, `warning: "*.proto" only matches files in skipped directories`) {
+		t.Error("missing warning for *.proto")
+	}
+	if strings.Contains(out, `"*.go"`) {
+		t.Error("unexpected warning for *.go, which matches watched files")
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.ConfigPath = filepath.Join(r.WorkDir, "runner.json")
+	r.WatchConfig = true
+	writeConfig := func(procs string) {
+		t.Helper()
+		if err := ioutil.WriteFile(r.ConfigPath, []byte(`{"procs": [`+procs+`]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const web = `{"name": "web", "cmd": ["touch \"$PS\"; exec sleep 30"]}`
+	writeConfig(web)
+	if err := r.Load(r.ConfigPath); err != nil {
+		t.Fatal(err)
+	}
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	stop := startRunner(t, &r)
+	defer stop()
+	if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) }) {
+		t.Fatal("web did not start")
+	}
+
+	writeConfig(web + `, {"name": "worker", "cmd": ["touch \"$PS\"; exec sleep 30"], "restart": "sometimes"}`)
+	if !eventually(t, func() bool { return strings.Contains(buf.String(), "keeping the current one") }) {
+		t.Fatal("the invalid configuration was not reported:", buf.String())
+	}
+	if fileExists(filepath.Join(r.WorkDir, "worker.0")) {
+		t.Error("the invalid configuration should not have been applied")
+	}
+
+	writeConfig(web + `, {"name": "worker", "cmd": ["touch \"$PS\"; exec sleep 30"]}`)
+	if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "worker.0")) }) {
+		t.Error("worker, added to the configuration, did not start:", buf.String())
+	}
+}
+
+func TestWatchConfigStatus(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.ConfigPath = filepath.Join(r.WorkDir, "runner.json")
+	r.WatchConfig = true
+	writeConfig := func(procs string) {
+		t.Helper()
+		if err := ioutil.WriteFile(r.ConfigPath, []byte(`{"procs": [`+procs+`]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const web = `{"name": "web", "cmd": ["exec sleep 30"]}`
+	writeConfig(web)
+	if err := r.Load(r.ConfigPath); err != nil {
+		t.Fatal(err)
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				r.Status()
+				r.DependencyGraph()
+			}
+		}
+	}()
+	if !eventually(t, func() bool { return len(r.Status()) == 1 }) {
+		t.Fatal("web did not start")
+	}
+	writeConfig(web + `, {"name": "worker", "cmd": ["exec sleep 30"]}`)
+	if !eventually(t, func() bool { return len(r.Status()) == 2 }) {
+		t.Error("worker, added to the configuration, did not start")
+	}
+}
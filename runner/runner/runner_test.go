@@ -0,0 +1,1001 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startRunner starts r in the background, inside a temporary work directory
+// when none is set. The returned function stops the runner and reports the
+// error returned by Start.
+func startRunner(t *testing.T, r *Runner) func() error {
+	t.Helper()
+	if r.WorkDir == "" {
+		r.WorkDir = tempDir(t)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- r.Start(ctx) }()
+	return func() error {
+		cancel()
+		return <-errc
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// eventually polls cond until it is true or until time runs out.
+func eventually(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	return false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestValidateFormation(t *testing.T) {
+	tests := []struct {
+		name      string
+		formation map[string]int
+		wantErr   string
+	}{
+		{"valid", map[string]int{"web": 2}, ""},
+		{"unknown", map[string]int{"wbe": 2}, `unknown process type "wbe"`},
+		{"build", map[string]int{"build-web": 2}, `"build-web" is a build process type`},
+		{"zero", map[string]int{"web": 0}, `"web" must have at least one instance, got 0`},
+		{"negative", map[string]int{"web": -1}, `"web" must have at least one instance, got -1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.Processes = []*ProcessType{
+				{Name: "build-web", Cmd: []string{"true"}},
+				{Name: "web", Cmd: []string{"true"}},
+			}
+			r.Formation = tt.formation
+			err := r.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatal("unexpected error:", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatal("expected error missing:", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("unexpected error message. got: %q, want: %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConcurrencyEnv(t *testing.T) {
+	setenv(t, "WEB_CONCURRENCY", "3")
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`touch "$PS"`}},
+	}
+	r.Formation["web"] = 1
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		fn := filepath.Join(r.WorkDir, fmt.Sprint("web.", i))
+		if !eventually(t, func() bool { return fileExists(fn) }) {
+			t.Error("instance not started:", fn)
+		}
+	}
+	if fileExists(filepath.Join(r.WorkDir, "web.3")) {
+		t.Error("unexpected fourth instance")
+	}
+}
+
+func TestValidatePortRange(t *testing.T) {
+	r := New()
+	r.BasePort = 65300
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"true"}},
+		{Name: "web", Cmd: []string{"true"}},
+		{Name: "worker", Cmd: []string{"true"}},
+	}
+	r.Formation["worker"] = 30
+	if err := r.Validate(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	r.Formation["worker"] = 40
+	err := r.Validate()
+	if err == nil {
+		t.Fatal("expected error missing")
+	}
+	const want = "worker.36: IP port 65536 is out of the valid range (1-65535)"
+	if err.Error() != want {
+		t.Errorf("unexpected error message. got: %q, want: %q", err, want)
+	}
+}
+
+func TestValidatePortCollision(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"true"}},
+		{Name: "worker", Cmd: []string{"true"}},
+	}
+	r.Formation["web"] = 100
+	if err := r.Validate(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	r.Formation["web"] = 101
+	err := r.Validate()
+	if err == nil {
+		t.Fatal("expected error missing")
+	}
+	const want = "web.100 and worker.0 are both assigned IP port 5100"
+	if err.Error() != want {
+		t.Errorf("unexpected error message. got: %q, want: %q", err, want)
+	}
+}
+
+func TestDefaultBasePort(t *testing.T) {
+	var r Runner
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"true"}},
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if got := r.assignedPorts(); !reflect.DeepEqual(got, map[int]string{DefaultBasePort: "web.0"}) {
+		t.Errorf("runners without BasePort should start from DefaultBasePort, got: %v", got)
+	}
+}
+
+func TestValidateNice(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "indexer", Cmd: []string{"true"}, Nice: 20},
+	}
+	if err := r.Validate(); err == nil {
+		t.Error("niceness out of range should be rejected")
+	}
+}
+
+func TestProcessEnvFiles(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.BaseEnvironment = []string{"PATH=" + os.Getenv("PATH"), "FOO=base"}
+	if err := ioutil.WriteFile(filepath.Join(r.WorkDir, "web.env"), []byte("FOO=web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`echo $FOO > "$PS.out"`}, EnvFiles: []string{"web.env"}},
+		{Name: "worker", Cmd: []string{`echo $FOO > "$PS.out"`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for name, want := range map[string]string{"web.0": "web", "worker.0": "base"} {
+		fn := filepath.Join(r.WorkDir, name+".out")
+		var got string
+		eventually(t, func() bool {
+			b, _ := ioutil.ReadFile(fn)
+			got = strings.TrimSpace(string(b))
+			return got != ""
+		})
+		if got != want {
+			t.Errorf("%s: unexpected FOO value. got: %q, want: %q", name, got, want)
+		}
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	setenv(t, "RUNNER_TEST_OS", "os")
+	setenv(t, "RUNNER_TEST_BASE", "os")
+	setenv(t, "RUNNER_TEST_PROC", "os")
+	r := New()
+	r.BaseEnvironment = []string{"RUNNER_TEST_BASE=base", "RUNNER_TEST_PROC=base"}
+	r.Processes = []*ProcessType{
+		{
+			Name: "web",
+			Cmd:  []string{`echo "$RUNNER_TEST_OS $RUNNER_TEST_BASE $RUNNER_TEST_PROC $(env | grep -c ^RUNNER_TEST_PROC=)" > "$PS.out"`},
+			Env:  []string{"RUNNER_TEST_PROC=first", "RUNNER_TEST_PROC=web"},
+		},
+		{Name: "worker", Cmd: []string{`echo "$RUNNER_TEST_OS $RUNNER_TEST_BASE $RUNNER_TEST_PROC" > "$PS.out"`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for name, want := range map[string]string{"web.0": "os base web 1", "worker.0": "os base base"} {
+		fn := filepath.Join(r.WorkDir, name+".out")
+		var got string
+		eventually(t, func() bool {
+			b, _ := ioutil.ReadFile(fn)
+			got = strings.TrimSpace(string(b))
+			return got != ""
+		})
+		if got != want {
+			t.Errorf("%s: unexpected environment. got: %q, want: %q", name, got, want)
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	got := mergeEnv([]string{"A=1", "B=1", "A=2", "=C:=C:\\", "B", "C=1"})
+	want := []string{"A=2", "B", "=C:=C:\\", "C=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected merged environment: %q, want: %q", got, want)
+	}
+}
+
+func TestSetBaseEnvironment(t *testing.T) {
+	r := New()
+	r.BaseEnvironment = []string{"PATH=" + os.Getenv("PATH"), "FOO=before"}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`echo $FOO > "$PS.out"; sleep 0.1`}, Restart: Always},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	fn := filepath.Join(r.WorkDir, "web.0.out")
+	read := func() string {
+		b, _ := ioutil.ReadFile(fn)
+		return strings.TrimSpace(string(b))
+	}
+	if !eventually(t, func() bool { return read() == "before" }) {
+		t.Fatal("web did not start with the original environment:", read())
+	}
+	r.SetBaseEnvironment([]string{"PATH=" + os.Getenv("PATH"), "FOO=after"})
+	if !eventually(t, func() bool { return read() == "after" }) {
+		t.Error("restarted process did not see the new environment:", read())
+	}
+}
+
+func TestBuildExportedEnv(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-version", Cmd: []string{`echo GIT_SHA=abc123 >> "$RUNNER_ENV_OUT"`}},
+		{Name: "web", Cmd: []string{`echo $GIT_SHA > "$PS.out"`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	fn := filepath.Join(r.WorkDir, "web.0.out")
+	var got string
+	eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(fn)
+		got = strings.TrimSpace(string(b))
+		return got != ""
+	})
+	if got != "abc123" {
+		t.Errorf("unexpected GIT_SHA value. got: %q, want: %q", got, "abc123")
+	}
+}
+
+func TestBuildArtifacts(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    string
+		wantOK bool
+	}{
+		{"produced", "echo binary > server", true},
+		{"missing", "true", false},
+		{"empty", "touch server", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = tempDir(t)
+			r.Processes = []*ProcessType{
+				{Name: "build-server", Cmd: []string{tt.cmd}, Artifacts: []string{"server"}},
+				{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}},
+			}
+			stop := startRunner(t, &r)
+			defer stop()
+
+			if !eventually(t, func() bool { _, when := r.LastBuildStatus(); return !when.IsZero() }) {
+				t.Fatal("the build did not complete")
+			}
+			if ok, _ := r.LastBuildStatus(); ok != tt.wantOK {
+				t.Fatalf("unexpected build status: %v, want: %v", ok, tt.wantOK)
+			}
+			if tt.wantOK && !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) }) {
+				t.Error("web should have started after the build")
+			}
+		})
+	}
+}
+
+func TestBuildWantPort(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`echo "${PORT:-none}" > "$PS.port"`}},
+		{Name: "build-integration", Cmd: []string{`echo "${PORT:-none}" > "$PS.port"`}, WantPort: true},
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	want := map[string]string{
+		"build-web":         "none",
+		"build-integration": fmt.Sprint(r.BasePort + 100),
+	}
+	for name, port := range want {
+		fn := filepath.Join(r.WorkDir, name+".port")
+		var got string
+		eventually(t, func() bool {
+			b, _ := ioutil.ReadFile(fn)
+			got = strings.TrimSpace(string(b))
+			return got != ""
+		})
+		if got != port {
+			t.Errorf("%s: unexpected PORT. got: %q, want: %q", name, got, port)
+		}
+	}
+	r.sdMu.Lock()
+	got := r.dynamicServiceDiscovery["BUILD_INTEGRATION_PORT"]
+	r.sdMu.Unlock()
+	if want := fmt.Sprint("localhost:", r.BasePort+100); got != want {
+		t.Errorf("unexpected discovery entry. got: %q, want: %q", got, want)
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	tests := []struct {
+		name string
+		line string
+		n    int
+		want string
+	}{
+		{"disabled", long, 0, long},
+		{"short", "abc", 10, "abc"},
+		{"exact", "abcdefghij", 10, "abcdefghij"},
+		{"long", long, 10, strings.Repeat("a", 10) + truncatedMark},
+		{"multibyte", "aaaaaaaaaé", 10, "aaaaaaaaa" + truncatedMark},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateLine(tt.line, tt.n); got != tt.want {
+				t.Errorf("truncateLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProcessTypeAddress(t *testing.T) {
+	r := New()
+	r.dynamicServiceDiscovery = map[string]string{
+		"BUILD_WEB":   "done",
+		"WEB2_0_PORT": "localhost:5100",
+		"WEB_0_PORT":  "localhost:5000",
+		"WEB_1_PORT":  "localhost:5001",
+	}
+	tests := []struct {
+		target, want string
+	}{
+		{"web", "localhost:5000"},
+		{"web.0", "localhost:5000"},
+		{"web.1", "localhost:5001"},
+		{"web2", "localhost:5100"},
+		{"build-web", "build-web"},
+		{"localhost:8888", "localhost:8888"},
+	}
+	for i := 0; i < 10; i++ {
+		for _, tt := range tests {
+			if got := r.resolveProcessTypeAddress(tt.target); got != tt.want {
+				t.Fatalf("resolveProcessTypeAddress(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestStableStartOrder(t *testing.T) {
+	run := func() []string {
+		r := New()
+		r.ServiceDiscoveryAddr = "localhost:0"
+		r.Processes = []*ProcessType{
+			{Name: "worker", Cmd: []string{"exec sleep 30"}, Group: "b"},
+			{Name: "web", Cmd: []string{"exec sleep 30"}, Group: "a"},
+			{Name: "db", Cmd: []string{"exec sleep 30"}},
+		}
+		r.Formation = map[string]int{"web": 3, "worker": 2}
+		stop := startRunner(t, &r)
+		defer stop()
+		var env []string
+		eventually(t, func() bool {
+			env = r.serviceDiscoveryEnv()
+			return len(env) == 6
+		})
+		return env
+	}
+
+	want := []string{
+		"WORKER_0_PORT=localhost:5000",
+		"WORKER_1_PORT=localhost:5001",
+		"WEB_0_PORT=localhost:5100",
+		"WEB_1_PORT=localhost:5101",
+		"WEB_2_PORT=localhost:5102",
+		"DB_0_PORT=localhost:5200",
+	}
+	for i := 0; i < 3; i++ {
+		if got := run(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("unstable start order (run %d). got: %v, want: %v", i, got, want)
+		}
+	}
+}
+
+func TestCommandFactory(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	r := New()
+	r.CommandFactory = func(_ context.Context, shell, cmd string) *exec.Cmd {
+		mu.Lock()
+		calls = append(calls, shell+" "+cmd)
+		mu.Unlock()
+		return exec.Command("env", "SANDBOXED=1", shell, "-c", cmd)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`echo "$SANDBOXED" > "$PS.out"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	fn := filepath.Join(r.WorkDir, "web.0.out")
+	var got string
+	eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(fn)
+		got = strings.TrimSpace(string(b))
+		return got != ""
+	})
+	if got != "1" {
+		t.Errorf("the command should have run inside the wrapper, got: %q", got)
+	}
+	mu.Lock()
+	if want := `sh echo "$SANDBOXED" > "$PS.out"; exec sleep 30`; len(calls) != 1 || calls[0] != want {
+		t.Errorf("unexpected calls to the factory: %q, want: %q", calls, want)
+	}
+	mu.Unlock()
+	want := [][]string{{"sh", "-c", `echo "$SANDBOXED" > "$PS.out"; exec sleep 30`}}
+	if got := r.EffectiveCommands("web"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected effective commands: %q, want: %q", got, want)
+	}
+}
+
+func TestDisableWatch(t *testing.T) {
+	for _, viaEnv := range []bool{false, true} {
+		t.Run(fmt.Sprint("env=", viaEnv), func(t *testing.T) {
+			var logs syncBuffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			r := New()
+			r.WorkDir = tempDir(t)
+			r.Observables = []string{"*.txt"}
+			if viaEnv {
+				setenv(t, "RUNNER_NO_WATCH", "1")
+			} else {
+				r.DisableWatch = true
+			}
+			trigger := filepath.Join(r.WorkDir, "trigger.txt")
+			if err := ioutil.WriteFile(trigger, []byte("1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r.Processes = []*ProcessType{
+				{Name: "web", Cmd: []string{`echo "$RUN_ID" >> runs; exec sleep 30`}},
+			}
+			stop := startRunner(t, &r)
+			defer stop()
+
+			runs := func() int {
+				b, _ := ioutil.ReadFile(filepath.Join(r.WorkDir, "runs"))
+				return strings.Count(string(b), "\n")
+			}
+			if !eventually(t, func() bool { return runs() == 1 }) {
+				t.Fatal("web did not start")
+			}
+			if err := ioutil.WriteFile(trigger, []byte("2\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Second)
+			if n := runs(); n != 1 {
+				t.Error("file changes should not restart the processes, runs:", n)
+			}
+			if out := logs.String(); strings.Contains(out, "monitoring") || !strings.Contains(out, "file watching disabled") {
+				t.Error("the file watcher should not have started:", out)
+			}
+		})
+	}
+}
+
+func TestSkipBuilds(t *testing.T) {
+	r := New()
+	r.SkipBuilds = true
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"touch built"}},
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) })
+	stop()
+	if !ok {
+		t.Fatal("web did not start")
+	}
+	if fileExists(filepath.Join(r.WorkDir, "built")) {
+		t.Error("build steps should not run when SkipBuilds is set")
+	}
+}
+
+func TestPidFile(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.PidFile = filepath.Join(r.WorkDir, "runner.pid")
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+
+	var got string
+	ok := eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(r.PidFile)
+		got = strings.TrimSpace(string(b))
+		return got != ""
+	})
+	if !ok {
+		stop()
+		t.Fatal("PID file not written")
+	}
+	if want := fmt.Sprint(os.Getpid()); got != want {
+		t.Errorf("unexpected PID. got: %q, want: %q", got, want)
+	}
+	if err := stop(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if fileExists(r.PidFile) {
+		t.Error("PID file should be removed once the runner stops")
+	}
+}
+
+func TestPostBuildDelay(t *testing.T) {
+	const delay = 500 * time.Millisecond
+	r := New()
+	r.PostBuildDelay = delay
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"date +%s%N > built"}},
+		{Name: "web", Cmd: []string{`date +%s%N > "$PS"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	started := filepath.Join(r.WorkDir, "web.0")
+	ok := eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(started)
+		return strings.HasSuffix(string(b), "\n")
+	})
+	stop()
+	if !ok {
+		t.Fatal("web did not start")
+	}
+
+	readTime := func(fn string) time.Time {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Unix(0, ns)
+	}
+	elapsed := readTime(started).Sub(readTime(filepath.Join(r.WorkDir, "built")))
+	if elapsed < delay {
+		t.Errorf("web started %v after the build, want at least %v", elapsed, delay)
+	}
+}
+
+func TestRunID(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Observables = []string{"*.txt"}
+	trigger := filepath.Join(r.WorkDir, "trigger.txt")
+	if err := ioutil.WriteFile(trigger, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`echo $RUN_ID > "$PS.runid"`}},
+		{Name: "web", Cmd: []string{`echo $RUN_ID > "$PS.runid"; exec sleep 30`}},
+		{Name: "worker", Cmd: []string{`echo $RUN_ID > "$PS.runid"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	runIDs := func() map[string]string {
+		ids := make(map[string]string)
+		for _, name := range []string{"build-web", "web.0", "worker.0"} {
+			b, _ := ioutil.ReadFile(filepath.Join(r.WorkDir, name+".runid"))
+			ids[name] = strings.TrimSpace(string(b))
+		}
+		return ids
+	}
+	shared := func(ids map[string]string) string {
+		var runID string
+		for _, id := range ids {
+			if id == "" || runID != "" && id != runID {
+				return ""
+			}
+			runID = id
+		}
+		return runID
+	}
+
+	var first string
+	if !eventually(t, func() bool { first = shared(runIDs()); return first != "" }) {
+		t.Fatal("processes of the same run should share a RUN_ID:", runIDs())
+	}
+	if err := ioutil.WriteFile(trigger, []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var second string
+	ok := eventually(t, func() bool {
+		second = shared(runIDs())
+		return second != "" && second != first
+	})
+	if !ok {
+		t.Error("RUN_ID should change after a restart:", runIDs())
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`echo $TRACEPARENT > "$PS.trace"`}},
+		{Name: "web", Cmd: []string{`echo $TRACEPARENT > "$PS.trace"; exec sleep 30`}},
+		{Name: "worker", Cmd: []string{`echo $TRACEPARENT > "$PS.trace"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	traceParent := regexp.MustCompile(`^00-([0-9a-f]{32})-[0-9a-f]{16}-01$`)
+	traceIDs := make(map[string]bool)
+	for _, name := range []string{"build-web", "web.0", "worker.0"} {
+		fn := filepath.Join(r.WorkDir, name+".trace")
+		var b []byte
+		if !eventually(t, func() bool { b, _ = ioutil.ReadFile(fn); return len(b) > 0 }) {
+			t.Fatal("missing TRACEPARENT of", name)
+		}
+		m := traceParent.FindStringSubmatch(strings.TrimSpace(string(b)))
+		if m == nil || m[1] == strings.Repeat("0", 32) {
+			t.Fatalf("malformed TRACEPARENT of %s: %q", name, b)
+		}
+		traceIDs[m[1]] = true
+	}
+	if len(traceIDs) != 1 {
+		t.Error("the processes of a generation should share the trace:", traceIDs)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	r := New()
+	r.Labels = map[string]string{"tenant": "acme", "deploy-env": "staging"}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`echo $RUNNER_LABEL_TENANT $RUNNER_LABEL_DEPLOY_ENV > "$PS.labels"`}},
+		{Name: "web", Cmd: []string{`echo $RUNNER_LABEL_TENANT $RUNNER_LABEL_DEPLOY_ENV > "$PS.labels"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for _, name := range []string{"build-web", "web.0"} {
+		fn := filepath.Join(r.WorkDir, name+".labels")
+		var b []byte
+		if !eventually(t, func() bool { b, _ = ioutil.ReadFile(fn); return len(b) > 0 }) {
+			t.Fatal("missing labels of", name)
+		}
+		if got := strings.TrimSpace(string(b)); got != "acme staging" {
+			t.Errorf("unexpected labels of %s: %q", name, got)
+		}
+	}
+	for _, st := range r.Status() {
+		if !reflect.DeepEqual(st.Labels, r.Labels) {
+			t.Errorf("%s: unexpected labels in the status: %v", st.Name, st.Labels)
+		}
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	r := New()
+	r.Labels = map[string]string{"deploy-env": "staging", "deploy.env": "prod"}
+	const want = `labels "deploy-env" and "deploy.env" are both passed as RUNNER_LABEL_DEPLOY_ENV`
+	if err := r.Validate(); err == nil || err.Error() != want {
+		t.Errorf("unexpected error: %v, want: %s", err, want)
+	}
+}
+
+func TestMaxBuildParallelism(t *testing.T) {
+	const limit = 2
+	r := New()
+	r.MaxBuildParallelism = limit
+	for i := 0; i < 6; i++ {
+		r.Processes = append(r.Processes, &ProcessType{
+			Name: fmt.Sprint("build-", i),
+			Cmd:  []string{`touch "$PS.on"; ls *.on | wc -l > "$PS.max"; sleep 0.2; rm "$PS.on"`},
+		})
+	}
+	r.Processes = append(r.Processes, &ProcessType{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}})
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "web.0")) })
+	stop()
+	if !ok {
+		t.Fatal("builds did not complete")
+	}
+	for i := 0; i < 6; i++ {
+		b, err := ioutil.ReadFile(filepath.Join(r.WorkDir, fmt.Sprint("build-", i, ".max")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := strconv.Atoi(strings.TrimSpace(string(b))); n > limit {
+			t.Errorf("build-%d saw %d builds running at once, want at most %d", i, n, limit)
+		}
+	}
+}
+
+func TestStdinFile(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.Output = &buf
+	r.WorkDir = tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(r.WorkDir, "input.txt"), []byte("alpha\nbravo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "reader", Cmd: []string{`while read line; do echo "got $line"; done; exec sleep 30`}, Group: "a", StdinFile: "input.txt"},
+		{Name: "missing", Cmd: []string{"cat"}, Group: "b", StdinFile: "missing.txt"},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool {
+		out := buf.String()
+		return strings.Contains(out, "got alpha") && strings.Contains(out, "got bravo") &&
+			strings.Contains(out, "cannot open stdin file:")
+	})
+	stop()
+	if !ok {
+		t.Fatal("unexpected output:", buf.String())
+	}
+}
+
+func TestPathPrepend(t *testing.T) {
+	var buf syncBuffer
+	r := New()
+	r.Output = &buf
+	r.WorkDir = tempDir(t)
+	bin := filepath.Join(r.WorkDir, "node_modules", ".bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho local tool called\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "local-tool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"local-tool; ls > /dev/null && echo system tools found; exec sleep 30"}, PathPrepend: []string{"node_modules/.bin"}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool {
+		out := buf.String()
+		return strings.Contains(out, "local tool called") && strings.Contains(out, "system tools found")
+	})
+	stop()
+	if !ok {
+		t.Fatal("unexpected output:", buf.String())
+	}
+}
+
+func TestWatchOnly(t *testing.T) {
+	var meta syncBuffer
+	r := New()
+	r.MetaOutput = &meta
+	r.WorkDir = tempDir(t)
+	r.WatchOnly = true
+	r.Observables = []string{"*.go"}
+	src := filepath.Join(r.WorkDir, "main.go")
+	if err := ioutil.WriteFile(src, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`touch "$PS"`}},
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool {
+		if err := ioutil.WriteFile(src, []byte("package main // changed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(meta.String(), "detected write "+src)
+	})
+	if err := stop(); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if !ok {
+		t.Error("change not reported:", meta.String())
+	}
+	for _, fn := range []string{"build-web", "web.0"} {
+		if fileExists(filepath.Join(r.WorkDir, fn)) {
+			t.Error("process type started in watch-only mode:", fn)
+		}
+	}
+}
+
+func TestSkipFiles(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Observables = []string{"*.txt", "*.log"}
+	r.SkipFiles = []string{"*.log"}
+	logFn := filepath.Join(r.WorkDir, "app.log")
+	trigger := filepath.Join(r.WorkDir, "trigger.txt")
+	for _, fn := range []string{logFn, trigger} {
+		if err := ioutil.WriteFile(fn, []byte("0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{`echo >> builds`}},
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	builds := func() int {
+		b, _ := ioutil.ReadFile(filepath.Join(r.WorkDir, "builds"))
+		return strings.Count(string(b), "\n")
+	}
+	if !eventually(t, func() bool { return builds() == 1 }) {
+		t.Fatal("first build did not run")
+	}
+	for i := 1; i <= 5; i++ {
+		if err := ioutil.WriteFile(logFn, []byte(fmt.Sprintln(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if n := builds(); n != 1 {
+		t.Fatal("changes to skipped files triggered builds:", n-1)
+	}
+	if err := ioutil.WriteFile(trigger, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(t, func() bool { return builds() >= 2 }) {
+		t.Error("changes to observed files should trigger a build")
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	dir := tempDir(t)
+	setenv(t, "RUNNER_TEST_DIR", dir)
+	setenv(t, "RUNNER_TEST_HOST", host)
+
+	r := New()
+	r.WorkDir = "$RUNNER_TEST_DIR"
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{`touch "$PS"; exec sleep 30`}, WaitFor: "${RUNNER_TEST_HOST}:" + port},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+	if !eventually(t, func() bool { return fileExists(filepath.Join(dir, "web.0")) }) {
+		t.Error("web.0 did not start in the expanded WorkDir after waiting for the expanded WaitFor")
+	}
+
+	r.SetBaseEnvironment([]string{"RUNNER_TEST_HOST=db.example.com"})
+	if got, want := mustExpandEnv(t, &r, r.Processes[0].WaitFor), "db.example.com:"+port; got != want {
+		t.Errorf("the base environment should take precedence. got: %q, want: %q", got, want)
+	}
+	if got, want := mustExpandEnv(t, &r, "${RUNNER_TEST_DIR}/x"), dir+"/x"; got != want {
+		t.Errorf("the runner environment should remain visible. got: %q, want: %q", got, want)
+	}
+	if got := mustExpandEnv(t, &r, "${RUNNER_TEST_UNSET}/x"); got != "/x" {
+		t.Errorf("unset variables should expand to empty. got: %q", got)
+	}
+}
+
+func TestExpandEnvBaseEnvironment(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	dir := tempDir(t)
+	setenv(t, "RUNNER_TEST_DIR", dir)
+	setenv(t, "RUNNER_TEST_HOST", "db.example.com")
+	if err := ioutil.WriteFile(filepath.Join(dir, "ready"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New()
+	r.WorkDir = "$RUNNER_TEST_DIR"
+	r.SetBaseEnvironment([]string{"RUNNER_TEST_HOST=" + host, "RUNNER_TEST_READY=ready"})
+	r.Processes = []*ProcessType{
+		{
+			Name:        "web",
+			Cmd:         []string{`touch "$PS"; exec sleep 30`},
+			WaitFor:     "${RUNNER_TEST_HOST}:" + port,
+			WaitForFile: "${RUNNER_TEST_DIR}/${RUNNER_TEST_READY}",
+		},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+	if !eventually(t, func() bool { return fileExists(filepath.Join(dir, "web.0")) }) {
+		t.Error("web.0 did not start with WorkDir, WaitFor and WaitForFile expanded from both the runner and the base environments")
+	}
+}
+
+func TestRestartModeStrict(t *testing.T) {
+	var r Runner
+	err := json.Unmarshal([]byte(`{"procs": [{"name": "web", "cmd": ["./web"], "restart": "onfailuer"}]}`), &r)
+	if err == nil || !strings.Contains(err.Error(), `unknown restart mode "onfailuer"`) {
+		t.Error("typo'd restart mode should be rejected at load, got:", err)
+	}
+
+	err = json.Unmarshal([]byte(`{"procs": [{"name": "web", "cmd": ["./web"], "restart": "on-failure"}]}`), &r)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if got := r.Processes[0].Restart; got != OnFailure {
+		t.Errorf("restart mode aliases should be converted. got: %q, want: %q", got, OnFailure)
+	}
+
+	v := New()
+	v.Processes = []*ProcessType{{Name: "web", Cmd: []string{"./web"}, Restart: "onfailuer"}}
+	if err := v.Validate(); err == nil {
+		t.Error("unknown restart modes should not pass validation")
+	}
+
+	if got := ParseRestartMode("onfailuer"); got != Never {
+		t.Errorf("lenient parsing should default to Never, got: %q", got)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package runnertest provides utilities to test runner configurations: it
+// starts a runner, captures the output and the progress events of its
+// processes, and asserts on them.
+package runnertest // import "cirello.io/runner/runner/runnertest"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cirello.io/runner/runner"
+)
+
+// DefaultTimeout is the Timeout of the runs returned by Start.
+const DefaultTimeout = 10 * time.Second
+
+// Run is a runner started by Start.
+type Run struct {
+	// Runner is the runner under test.
+	Runner *runner.Runner
+	// Timeout is how long WaitReady and the assertions wait for their
+	// conditions to be met.
+	Timeout time.Duration
+
+	t      testing.TB
+	cancel context.CancelFunc
+	exited chan struct{}
+
+	mu     sync.Mutex
+	lines  []line
+	events []runner.ProgressEvent
+	err    error // returned by Start
+}
+
+// line is a line of output of a process.
+type line struct {
+	name string // process name (e.g. "web.0")
+	text string
+}
+
+// Start starts r, capturing the output and the progress events of its
+// processes. The runner is stopped once the test completes. If r.Output is
+// nil, the output is discarded instead of written to the standard output; it
+// is still reported by the failed assertions.
+func Start(t testing.TB, r *runner.Runner) *Run {
+	t.Helper()
+	run := &Run{
+		Runner:  r,
+		Timeout: DefaultTimeout,
+		t:       t,
+		exited:  make(chan struct{}),
+	}
+	if r.Output == nil {
+		r.Output = ioutil.Discard
+	}
+	events := &eventWriter{run: run}
+	if r.ProgressOutput != nil {
+		r.ProgressOutput = io.MultiWriter(r.ProgressOutput, events)
+	} else {
+		r.ProgressOutput = events
+	}
+	output := r.OutputReader()
+	go run.collect(output)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run.cancel = cancel
+	go func() {
+		err := r.Start(ctx)
+		output.Close()
+		run.mu.Lock()
+		run.err = err
+		run.mu.Unlock()
+		close(run.exited)
+	}()
+	t.Cleanup(func() { run.Stop() })
+	return run
+}
+
+func (run *Run) collect(output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 65536), 2*1048576)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		run.mu.Lock()
+		run.lines = append(run.lines, line{name: parts[0], text: parts[1]})
+		run.mu.Unlock()
+	}
+}
+
+// eventWriter decodes the progress events of the runner.
+type eventWriter struct {
+	run *Run
+	buf []byte
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		var ev runner.ProgressEvent
+		if err := json.Unmarshal(w.buf[:i], &ev); err == nil {
+			w.run.mu.Lock()
+			w.run.events = append(w.run.events, ev)
+			w.run.mu.Unlock()
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Stop stops the runner and waits for it to exit, returning the error of
+// Runner.Start.
+func (run *Run) Stop() error {
+	run.cancel()
+	<-run.exited
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.err
+}
+
+// Lines lists the lines of output of the process type or process (e.g. "web"
+// or "web.0"), in the order they were printed. An empty name lists the lines of
+// all processes, prefixed with their names (e.g. "web.0: listening").
+func (run *Run) Lines(name string) []string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	var lines []string
+	for _, l := range run.lines {
+		switch {
+		case name == "":
+			lines = append(lines, l.name+": "+l.text)
+		case matchName(name, l.name):
+			lines = append(lines, l.text)
+		}
+	}
+	return lines
+}
+
+// Events lists the progress events reported so far.
+func (run *Run) Events() []runner.ProgressEvent {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return append([]runner.ProgressEvent(nil), run.events...)
+}
+
+// WaitReady waits for all the processes of the runner to become ready, failing
+// the test right away if they do not within Timeout.
+func (run *Run) WaitReady() {
+	run.t.Helper()
+	ready := func() bool {
+		for _, ev := range run.Events() {
+			if ev.Type == runner.ProgressAllReady {
+				return true
+			}
+		}
+		return false
+	}
+	if !run.eventually(ready) {
+		run.t.Fatalf("processes not ready%s\n%s", run.why(), run.dump())
+	}
+}
+
+// AssertStarted checks that the process (e.g. "web.0") was started, failing
+// the test if it is not within Timeout.
+func (run *Run) AssertStarted(name string) bool {
+	run.t.Helper()
+	if run.eventually(func() bool { st, ok := run.status(name); return ok && st.Starts > 0 }) {
+		return true
+	}
+	run.t.Errorf("%s was not started%s\n%s", name, run.why(), run.dump())
+	return false
+}
+
+// AssertLine checks that a line of output of the process type or process
+// (e.g. "web" or "web.0") matches the regular expression expr, failing the
+// test if none does within Timeout.
+func (run *Run) AssertLine(name, expr string) bool {
+	run.t.Helper()
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		run.t.Errorf("invalid expression: %v", err)
+		return false
+	}
+	matched := func() bool {
+		for _, l := range run.Lines(name) {
+			if re.MatchString(l) {
+				return true
+			}
+		}
+		return false
+	}
+	if run.eventually(matched) {
+		return true
+	}
+	run.t.Errorf("no line of %s matched %q%s\n%s", name, expr, run.why(), run.dump())
+	return false
+}
+
+// AssertPort checks that the process (e.g. "web.0") was assigned the IP
+// port.
+func (run *Run) AssertPort(name string, port int) bool {
+	run.t.Helper()
+	if !run.eventually(func() bool { _, ok := run.status(name); return ok }) {
+		run.t.Errorf("%s was not started%s\n%s", name, run.why(), run.dump())
+		return false
+	}
+	if st, _ := run.status(name); st.Port != port {
+		run.t.Errorf("%s was assigned IP port %d, want: %d", name, st.Port, port)
+		return false
+	}
+	return true
+}
+
+func (run *Run) status(name string) (runner.ProcessStatus, bool) {
+	for _, st := range run.Runner.Status() {
+		if st.Name == name {
+			return st, true
+		}
+	}
+	return runner.ProcessStatus{}, false
+}
+
+// eventually polls cond until it is true, Timeout is reached or the runner
+// exits.
+func (run *Run) eventually(cond func() bool) bool {
+	deadline := time.Now().Add(run.Timeout)
+	for {
+		if cond() {
+			return true
+		}
+		select {
+		case <-run.exited:
+			return cond()
+		default:
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// why explains why a condition was not met, if the runner exited.
+func (run *Run) why() string {
+	select {
+	case <-run.exited:
+	default:
+		return ""
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.err != nil {
+		return ", the runner stopped: " + run.err.Error()
+	}
+	return ", the runner stopped"
+}
+
+func (run *Run) dump() string {
+	lines := run.Lines("")
+	if len(lines) == 0 {
+		return "(no output)"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func matchName(filter, name string) bool {
+	return name == filter || strings.HasPrefix(name, filter+".")
+}
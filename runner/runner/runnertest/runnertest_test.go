@@ -0,0 +1,143 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runnertest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cirello.io/runner/runner"
+)
+
+func newRunner(t *testing.T, procs ...*runner.ProcessType) *runner.Runner {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "runnertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	r := runner.New()
+	r.WorkDir = dir
+	r.Processes = procs
+	return &r
+}
+
+func TestRun(t *testing.T) {
+	r := newRunner(t,
+		&runner.ProcessType{Name: "build-web", Cmd: []string{"echo built"}},
+		&runner.ProcessType{Name: "web", Cmd: []string{`echo "listening on $PORT"; exec sleep 30`}},
+	)
+	run := Start(t, r)
+	run.WaitReady()
+
+	run.AssertStarted("web.0")
+	run.AssertLine("web", `^listening on 5100$`)
+	run.AssertLine("build-web", `^built$`)
+	run.AssertPort("web.0", r.BasePort+100) // the second process type
+
+	var ready bool
+	for _, ev := range run.Events() {
+		ready = ready || ev.Type == runner.ProgressReady && ev.Name == "web.0"
+	}
+	if !ready {
+		t.Errorf("missing ready event for web.0: %+v", run.Events())
+	}
+	if lines := run.Lines(""); len(lines) == 0 || !strings.HasPrefix(lines[0], "build-web: ") {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if err := run.Stop(); err != nil {
+		t.Error("unexpected error stopping the runner:", err)
+	}
+}
+
+// recorder collects the failures of the assertions, instead of failing the
+// test.
+type recorder struct {
+	testing.TB
+	mu       sync.Mutex
+	failures []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// run runs f in a goroutine, as Fatalf stops it, and returns the failures
+// it recorded.
+func (r *recorder) run(f func()) []string {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failures := r.failures
+	r.failures = nil
+	return failures
+}
+
+func TestFailedAssertions(t *testing.T) {
+	rec := &recorder{TB: t}
+	r := newRunner(t, &runner.ProcessType{Name: "web", Cmd: []string{`echo "listening on $PORT"; exec sleep 30`}})
+	run := Start(rec, r)
+	run.Timeout = 200 * time.Millisecond
+	if failures := rec.run(run.WaitReady); len(failures) > 0 {
+		t.Fatal("unexpected failures:", failures)
+	}
+
+	tests := []struct {
+		name   string
+		assert func()
+		want   string
+	}{
+		{"started", func() { run.AssertStarted("db.0") }, "db.0 was not started"},
+		{"line", func() { run.AssertLine("web", "^ready$") }, `no line of web matched "^ready$"`},
+		{"port", func() { run.AssertPort("web.0", 6000) }, "web.0 was assigned IP port 5000, want: 6000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := rec.run(tt.assert)
+			if len(failures) != 1 || !strings.HasPrefix(failures[0], tt.want) {
+				t.Errorf("unexpected failures: %q, want: %q", failures, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitReadyRunnerStopped(t *testing.T) {
+	rec := &recorder{TB: t}
+	r := newRunner(t, &runner.ProcessType{Name: "web", Cmd: []string{"exec sleep 30"}})
+	r.Formation = map[string]int{"db": 1}
+	run := Start(rec, r)
+	failures := rec.run(run.WaitReady)
+	if len(failures) != 1 || !strings.Contains(failures[0], `the runner stopped: formation: unknown process type "db"`) {
+		t.Errorf("unexpected failures: %q", failures)
+	}
+}
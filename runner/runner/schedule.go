@@ -0,0 +1,187 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// schedule tells when the next run of a scheduled process type is due.
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// parseSchedule parses the Schedule of a process type: either an interval
+// (e.g. "5m" or "@every 5m") or a cron expression with the five standard
+// fields: minute, hour, day of month, month and day of week.
+func parseSchedule(s string) (schedule, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(s, "@every"))); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval must be positive, got %v", d)
+		}
+		return interval(d), nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q is neither an interval nor a cron expression with 5 fields", s)
+	}
+	var (
+		cs  cronSchedule
+		err error
+	)
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&cs.minute, 0, 59},
+		{&cs.hour, 0, 23},
+		{&cs.dom, 1, 31},
+		{&cs.month, 1, 12},
+		{&cs.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.set, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, err
+		}
+	}
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1 // both 0 and 7 are Sunday
+	}
+	cs.anyDOM, cs.anyDOW = fields[2] == "*", fields[4] == "*"
+	return cs, nil
+}
+
+type interval time.Duration
+
+func (i interval) next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
+
+// cronSchedule holds the values allowed for each field of a cron expression
+// as bit sets.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDOM, anyDOW                bool
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			var err error
+			lo, err = strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			if step == 1 {
+				hi = lo
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of the valid range (%d-%d)", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (cs cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Impossible expressions (e.g. February 30th) never match.
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case !has(cs.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !cs.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !has(cs.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !has(cs.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay follows the cron convention: if both the day of month and the day
+// of week are restricted, either of them matching is enough.
+func (cs cronSchedule) matchDay(t time.Time) bool {
+	dom, dow := has(cs.dom, t.Day()), has(cs.dow, int(t.Weekday()))
+	if cs.anyDOM || cs.anyDOW {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+// runScheduled starts the instance of sv on its schedule, until ctx is done.
+// The runs that are due while the previous one is still going are skipped.
+func (r *Runner) runScheduled(ctx context.Context, sched schedule, sv *ProcessType, instance, portCount int, changedFileName string) {
+	procName := fmt.Sprintf("%v.%v", sv.Name, instance)
+	var running int32
+	for {
+		next := sched.next(r.clock().Now())
+		if next.IsZero() {
+			fmt.Fprintln(r.metaOutput(), "no upcoming run of", procName, "in its schedule")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.clock().After(next.Sub(r.clock().Now())):
+		}
+		if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+			fmt.Fprintln(r.metaOutput(), "skipping scheduled run of", procName+", the previous one is still running")
+			continue
+		}
+		go func() {
+			defer atomic.StoreInt32(&running, 0)
+			r.startProcess(ctx, sv, instance, portCount, changedFileName)
+		}()
+	}
+}
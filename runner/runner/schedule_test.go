@@ -0,0 +1,110 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	now := at("2024-01-31 10:07") // Wednesday
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"5m", now.Add(5 * time.Minute)},
+		{"@every 90s", now.Add(90 * time.Second)},
+		{"* * * * *", at("2024-01-31 10:08")},
+		{"*/15 * * * *", at("2024-01-31 10:15")},
+		{"0 9-17 * * *", at("2024-01-31 11:00")},
+		{"30 8 * * *", at("2024-02-01 08:30")},
+		{"0 0 1 * *", at("2024-02-01 00:00")},
+		{"0 12 * * 1,5", at("2024-02-02 12:00")},
+		{"0 12 * * 7", at("2024-02-04 12:00")},
+		{"0 0 29 2 *", at("2024-02-29 00:00")},
+		{"0 0 13 * 5", at("2024-02-02 00:00")},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		sched, err := parseSchedule(tt.schedule)
+		if err != nil {
+			t.Errorf("%q: %v", tt.schedule, err)
+			continue
+		}
+		if got := sched.next(now); !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.schedule, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "-5m", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseSchedule(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Processes = []*ProcessType{
+		{Name: "refresh", Cmd: []string{"echo >> runs"}, Schedule: "@every 100ms"},
+		{Name: "slow", Cmd: []string{"set -C; : > lock || echo >> overlaps; sleep 0.3; rm lock"}, Schedule: "100ms"},
+	}
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return starts("refresh.0") >= 3 }) {
+		t.Error("scheduled process type should run multiple times")
+	}
+	if !eventually(t, func() bool { return starts("slow.0") >= 3 }) {
+		t.Error("scheduled process type should run multiple times")
+	}
+	if n := starts("slow.0"); n > 6 {
+		t.Error("runs overlapping the previous one should be skipped, starts:", n)
+	}
+	if _, err := os.Stat(filepath.Join(r.WorkDir, "overlaps")); err == nil {
+		t.Error("scheduled runs overlapped")
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{{Name: "web", Cmd: []string{"true"}, Schedule: "every minute"}}
+	if err := r.Validate(); err == nil {
+		t.Error("invalid schedules should be rejected")
+	}
+	r.Processes = []*ProcessType{{Name: "build-web", Cmd: []string{"true"}, Schedule: "1m"}}
+	if err := r.Validate(); err == nil {
+		t.Error("schedules should be rejected for build process types")
+	}
+}
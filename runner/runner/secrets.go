@@ -0,0 +1,109 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretCommandTimeout is how long the SecretCommand of a process type is
+// given to output the secrets.
+const secretCommandTimeout = 30 * time.Second
+
+// minRedactedSecretLength is the length under which secret values are not
+// redacted from the output, as they would mangle it.
+const minRedactedSecretLength = 4
+
+// loadSecrets runs the SecretCommand of the process type, returning the
+// variables it outputs.
+func (r *Runner) loadSecrets(ctx context.Context, sv *ProcessType) ([]string, error) {
+	if len(sv.SecretCommand) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, secretCommandTimeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, sv.SecretCommand[0], sv.SecretCommand[1:]...)
+	c.Dir = r.WorkDir
+	c.Env = mergeEnv(append(os.Environ(), r.baseEnvironment()...))
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %v", sv.SecretCommand[0], secretCommandTimeout)
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", sv.SecretCommand[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", sv.SecretCommand[0], err)
+	}
+	var env []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && kv[0] != "" {
+			env = append(env, line)
+		}
+	}
+	return env, nil
+}
+
+// secretRedactions hide the secret values from the output of each process.
+type secretRedactions struct {
+	mu        sync.Mutex
+	replacers map[string]*strings.Replacer // map of process name to its redactions
+}
+
+func (sr *secretRedactions) set(procName string, secrets []string) {
+	var values []string
+	for _, kv := range secrets {
+		if value := strings.SplitN(kv, "=", 2)[1]; len(value) >= minRedactedSecretLength {
+			values = append(values, value)
+		}
+	}
+	// Longer values first, so the ones that contain others are redacted
+	// whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var oldnew []string
+	for _, value := range values {
+		oldnew = append(oldnew, value, "[redacted]")
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if len(oldnew) == 0 {
+		delete(sr.replacers, procName)
+		return
+	}
+	if sr.replacers == nil {
+		sr.replacers = make(map[string]*strings.Replacer)
+	}
+	sr.replacers[procName] = strings.NewReplacer(oldnew...)
+}
+
+func (sr *secretRedactions) redact(procName, line string) string {
+	sr.mu.Lock()
+	replacer, ok := sr.replacers[procName]
+	sr.mu.Unlock()
+	if !ok {
+		return line
+	}
+	return replacer.Replace(line)
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretCommand(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	var out, meta syncBuffer
+	r.Output, r.MetaOutput = &out, &meta
+	r.Processes = []*ProcessType{
+		{
+			Name:          "web",
+			SecretCommand: []string{"sh", "-c", "echo API_TOKEN=s3cr3t==; echo SHORT=abc"},
+			Cmd:           []string{`test "$API_TOKEN" = s3cr3t== && touch ok; echo "token is $API_TOKEN, short is $SHORT"; exec sleep 30`},
+		},
+		{
+			Name:          "worker",
+			SecretCommand: []string{"sh", "-c", "echo vault is sealed >&2; exit 1"},
+			Cmd:           []string{"touch worker-started"},
+		},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return fileExists(filepath.Join(r.WorkDir, "ok")) }) {
+		t.Error("secrets not injected into the environment")
+	}
+	if !eventually(t, func() bool { return strings.Contains(out.String(), "token is [redacted], short is abc") }) {
+		t.Error("secret not redacted from the output:", out.String())
+	}
+	if strings.Contains(out.String(), "s3cr3t") {
+		t.Error("secret leaked into the output")
+	}
+	if !eventually(t, func() bool {
+		return strings.Contains(meta.String(), "cannot load secrets: sh: exit status 1: vault is sealed")
+	}) {
+		t.Error("missing secret command failure:", meta.String())
+	}
+	if fileExists(filepath.Join(r.WorkDir, "worker-started")) {
+		t.Error("process started despite the secret command failure")
+	}
+}
@@ -20,11 +20,18 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"runtime/debug"
+	"strings"
 
 	supervisor "cirello.io/supervisor/easy"
 )
 
 func (r *Runner) serveServiceDiscovery(ctx context.Context) error {
+	if r.Mux != nil {
+		r.muxOnce.Do(r.registerMux)
+		return nil
+	}
+
 	addr := r.ServiceDiscoveryAddr
 	if addr == "" {
 		return nil
@@ -35,20 +42,13 @@ func (r *Runner) serveServiceDiscovery(ctx context.Context) error {
 		return err
 	}
 	log.Println("starting service discovery on", l.Addr())
+	r.sdMu.Lock()
 	r.ServiceDiscoveryAddr = l.Addr().String()
+	r.sdMu.Unlock()
 
 	go func() {
 		mux := http.NewServeMux()
-		mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
-			enc := json.NewEncoder(w)
-			enc.SetIndent("", "    ")
-			r.sdMu.Lock()
-			defer r.sdMu.Unlock()
-			err := enc.Encode(r.dynamicServiceDiscovery)
-			if err != nil {
-				log.Println("cannot serve service discovery request:", err)
-			}
-		})
+		r.registerHandlers(mux, "/")
 
 		server := &http.Server{
 			Addr:    ":0",
@@ -65,3 +65,143 @@ func (r *Runner) serveServiceDiscovery(ctx context.Context) error {
 	}()
 	return nil
 }
+
+// registerMux registers the HTTP services of the runner on Mux, under
+// MuxPrefix. A ServeMux panics on repeated patterns, so it must be called only
+// once.
+func (r *Runner) registerMux() {
+	prefix := r.muxPrefix()
+	if prefix == "" {
+		r.registerHandlers(r.Mux, "/discovery")
+		return
+	}
+	mux := http.NewServeMux()
+	r.registerHandlers(mux, "/discovery")
+	r.Mux.Handle(prefix+"/", http.StripPrefix(prefix, mux))
+}
+
+func (r *Runner) muxPrefix() string {
+	return strings.TrimSuffix(r.MuxPrefix, "/")
+}
+
+// registerHandlers registers the HTTP services of the runner on mux, with the
+// process types ports served on discoveryPath.
+func (r *Runner) registerHandlers(mux *http.ServeMux, discoveryPath string) {
+	mux.Handle(discoveryPath, recoverPanics(r.serveDiscovery))
+	mux.Handle("/logs", recoverPanics(r.serveLogs))
+	mux.Handle("/kv/", recoverPanics(r.serveKV))
+	mux.Handle("/restarts/", recoverPanics(r.serveRestarts))
+	mux.Handle("/procs", recoverPanics(r.serveProcs))
+}
+
+// recoverPanics keeps a panic in h from going unnoticed: it is logged, along
+// with the request that caused it, and answered with an internal server
+// error if nothing was written yet. The requests that follow are served
+// normally.
+func recoverPanics(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rw := &panicResponseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("panic serving %s %s: %v\n%s", req.Method, req.URL, p, debug.Stack())
+			if !rw.wroteHeader {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h(rw, req)
+	})
+}
+
+// panicResponseWriter tracks whether the response was started, so a
+// recovered panic is only answered with an error when it was not.
+type panicResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *panicResponseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *panicResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps the streaming of /logs working through the wrapper.
+func (w *panicResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// discoveryEnv is the value of the environment variable DISCOVERY given to
+// the processes.
+func (r *Runner) discoveryEnv() string {
+	if r.Mux != nil {
+		return r.ServiceDiscoveryAddr + r.muxPrefix() + "/discovery"
+	}
+	return r.ServiceDiscoveryAddr
+}
+
+func (r *Runner) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	r.sdMu.Lock()
+	defer r.sdMu.Unlock()
+	err := enc.Encode(r.dynamicServiceDiscovery)
+	if err != nil {
+		log.Println("cannot serve service discovery request:", err)
+	}
+}
+
+// procStatus describes a process instance on the path /procs.
+type procStatus struct {
+	Name         string `json:"name"`
+	Port         int    `json:"port"`
+	PID          int    `json:"pid,omitempty"`
+	Ready        bool   `json:"ready"`
+	Starts       int    `json:"starts"`
+	LastExitCode int    `json:"lastExitCode"`
+}
+
+func (r *Runner) serveProcs(w http.ResponseWriter, _ *http.Request) {
+	statuses := make(map[string]ProcessStatus)
+	for _, st := range r.Status() {
+		statuses[st.Name] = st
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(r.procStatuses(statuses)); err != nil {
+		log.Println("cannot serve process list request:", err)
+	}
+}
+
+func (r *Runner) procStatuses(statuses map[string]ProcessStatus) []procStatus {
+	procs := []procStatus{}
+	r.liveMu.Lock()
+	defer r.liveMu.Unlock()
+	for _, inst := range r.plan() {
+		st := procStatus{
+			Name:  inst.name,
+			Port:  inst.port,
+			Ready: r.readiness.isReady(inst.name),
+		}
+		if status, ok := statuses[inst.name]; ok {
+			st.Starts, st.LastExitCode = status.Starts, status.LastExitCode
+		}
+		if p, ok := r.live[inst.name]; ok {
+			st.PID = p.Pid
+		}
+		procs = append(procs, st)
+	}
+	return procs
+}
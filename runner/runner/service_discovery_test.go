@@ -0,0 +1,186 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSharedMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Mux = mux
+	r.ServiceDiscoveryAddr = strings.TrimPrefix(server.URL, "http://")
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"echo $DISCOVERY > discovery; exec sleep 30"}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	discoveryFile := filepath.Join(r.WorkDir, "discovery")
+	if !eventually(t, func() bool { return fileExists(discoveryFile) }) {
+		t.Fatal("web did not start")
+	}
+	if !eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(discoveryFile)
+		return strings.TrimSpace(string(b)) == r.ServiceDiscoveryAddr+"/discovery"
+	}) {
+		b, _ := ioutil.ReadFile(discoveryFile)
+		t.Errorf("unexpected DISCOVERY: %q", b)
+	}
+
+	do := func(method, path string) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if path == "/logs" {
+			return resp.StatusCode, nil
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+	if code, _ := do(http.MethodGet, "/health"); code != http.StatusOK {
+		t.Error("the handlers of the mux owner should be kept, status:", code)
+	}
+	if !eventually(t, func() bool {
+		code, body := do(http.MethodGet, "/discovery")
+		var ports map[string]string
+		return code == http.StatusOK && json.Unmarshal(body, &ports) == nil && ports["WEB_0_PORT"] != ""
+	}) {
+		t.Error("the process types ports are not served on /discovery")
+	}
+	code, body := do(http.MethodGet, "/procs")
+	var procs []procStatus
+	if err := json.Unmarshal(body, &procs); code != http.StatusOK || err != nil {
+		t.Fatalf("unexpected process list. status: %d, error: %v", code, err)
+	}
+	if len(procs) != 1 || procs[0].Name != "web.0" || procs[0].Port != r.BasePort || procs[0].PID == 0 {
+		t.Errorf("unexpected process list: %+v", procs)
+	}
+	if code, _ := do(http.MethodPut, "/kv/key"); code != http.StatusNoContent {
+		t.Error("unexpected status writing to the key/value store:", code)
+	}
+	if code, _ := do(http.MethodPost, "/restarts/pause"); code != http.StatusNoContent {
+		t.Error("unexpected status pausing the restarts:", code)
+	}
+	if code, _ := do(http.MethodGet, "/logs"); code != http.StatusOK {
+		t.Error("unexpected status attaching to the output:", code)
+	}
+}
+
+func TestSharedMuxPrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/procs", func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("app")) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Mux = mux
+	r.MuxPrefix = "runner"
+	if err := r.Validate(); err == nil || !strings.Contains(err.Error(), "mux prefix must start with /") {
+		t.Error("relative mux prefixes should be rejected, got:", err)
+	}
+	r.MuxPrefix = "/runner/"
+	r.ServiceDiscoveryAddr = strings.TrimPrefix(server.URL, "http://")
+	r.Processes = []*ProcessType{
+		{Name: "web", Cmd: []string{"echo $DISCOVERY > discovery; exec sleep 30"}},
+	}
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	stop := startRunner(t, &r)
+	discoveryFile := filepath.Join(r.WorkDir, "discovery")
+	if !eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(discoveryFile)
+		return strings.TrimSpace(string(b)) == r.ServiceDiscoveryAddr+"/runner/discovery"
+	}) {
+		b, _ := ioutil.ReadFile(discoveryFile)
+		t.Errorf("unexpected DISCOVERY: %q", b)
+	}
+	if code, body := get("/procs"); code != http.StatusOK || body != "app" {
+		t.Errorf("the routes of the mux owner should be kept. status: %d, body: %q", code, body)
+	}
+	if code, body := get("/runner/procs"); code != http.StatusOK || !strings.Contains(body, "web.0") {
+		t.Errorf("the process list should be served under the prefix. status: %d, body: %q", code, body)
+	}
+	stop()
+
+	stop = startRunner(t, &r)
+	defer stop()
+	if code, _ := get("/runner/discovery"); code != http.StatusOK {
+		t.Error("the services should still be served after starting again, status:", code)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	r := New()
+	mux := http.NewServeMux()
+	r.registerHandlers(mux, "/")
+	mux.Handle("/panic", recoverPanics(func(http.ResponseWriter, *http.Request) {
+		var kv map[string]string
+		kv["key"] = "value"
+	}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatal("the panic should have been answered:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Error("unexpected status code for the panic:", resp.StatusCode)
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(server.URL + "/procs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Error("requests after the panic should be served, got:", resp.StatusCode)
+		}
+		if resp, err := http.Get(server.URL + "/panic"); err == nil {
+			resp.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// shutdown coordinates the termination of the processes once the runner is
+// stopped.
+type shutdown struct {
+	mu       sync.Mutex
+	stopping chan struct{}
+	force    chan struct{}
+	running  sync.WaitGroup
+}
+
+func (s *shutdown) init() {
+	if s.stopping == nil {
+		s.stopping = make(chan struct{})
+		s.force = make(chan struct{})
+	}
+}
+
+// track registers a starting process. It returns false if the runner is
+// already stopping, in which case the process must not be started.
+func (s *shutdown) track() (release func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	select {
+	case <-s.stopping:
+		return nil, false
+	default:
+	}
+	s.running.Add(1)
+	return s.running.Done, true
+}
+
+func (s *shutdown) channels() (stopping, force <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return s.stopping, s.force
+}
+
+func (s *shutdown) isStopping() bool {
+	stopping, _ := s.channels()
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop asks all processes to terminate and waits for them to exit.
+func (s *shutdown) stop() {
+	s.mu.Lock()
+	s.init()
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
+	}
+	s.mu.Unlock()
+	s.running.Wait()
+}
+
+func (s *shutdown) forceStop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	select {
+	case <-s.force:
+	default:
+		close(s.force)
+	}
+}
+
+// ForceStop kills the processes that are still running without waiting for
+// the rest of ShutdownGracePeriod. It is meant to be called once the context
+// given to Start is cancelled, for instance upon a second interrupt from the
+// terminal. Once called, all subsequent terminations are immediate.
+func (r *Runner) ForceStop() {
+	r.shutdown.forceStop()
+}
+
+// terminateOnCancel stops p once ctx is cancelled or the runner is stopping:
+// it is asked to terminate and, if it does not exit within
+// ShutdownGracePeriod, it is killed. The returned function must be called once
+// p exits; it reports whether p was stopped this way.
+func (r *Runner) terminateOnCancel(ctx context.Context, p *os.Process) func() bool {
+	done := make(chan struct{})
+	var stopping int32
+	stop, force := r.shutdown.channels()
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		case <-stop:
+		}
+		atomic.StoreInt32(&stopping, 1)
+		select {
+		case <-force:
+			killProcess(p)
+			return
+		default:
+		}
+		if r.ShutdownGracePeriod <= 0 {
+			killProcess(p)
+			return
+		}
+		terminateProcess(p)
+		grace := r.clock().NewTimer(r.ShutdownGracePeriod)
+		defer grace.Stop()
+		select {
+		case <-done:
+		case <-grace.C():
+			killProcess(p)
+		case <-force:
+			killProcess(p)
+		}
+	}()
+	return func() bool {
+		close(done)
+		return atomic.LoadInt32(&stopping) == 1
+	}
+}
+
+// limitRuntime derives from ctx the context of an instance of sv, cancelled
+// once the instance outlives the process type MaxRuntime. reached reports
+// whether that happened, and stop releases the resources once the instance
+// exits.
+func (r *Runner) limitRuntime(ctx context.Context, w io.Writer, sv *ProcessType) (_ context.Context, reached func() bool, stop func()) {
+	if sv.MaxRuntime <= 0 {
+		return ctx, func() bool { return false }, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	expired := make(chan struct{})
+	timer := r.clock().NewTimer(sv.MaxRuntime)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C():
+			close(expired)
+			fmt.Fprintln(w, "maximum runtime of", sv.MaxRuntime, "reached, stopping")
+			cancel()
+		}
+	}()
+	stop = cancel
+	return ctx, func() bool {
+		select {
+		case <-expired:
+			return true
+		default:
+			return false
+		}
+	}, stop
+}
+
+// limitStart stops a command of sv, through cancel, if it does not output a
+// line within the process type StartTimeout. firstOutput must be closed once
+// the command outputs its first line. reached reports whether the command was
+// stopped, and stop releases the resources once the command exits.
+func (r *Runner) limitStart(w io.Writer, sv *ProcessType, firstOutput <-chan struct{}, cancel func()) (reached func() bool, stop func()) {
+	if sv.StartTimeout <= 0 {
+		return func() bool { return false }, func() {}
+	}
+	expired := make(chan struct{})
+	released := make(chan struct{})
+	timer := r.clock().NewTimer(sv.StartTimeout)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-firstOutput:
+		case <-released:
+		case <-timer.C():
+			close(expired)
+			fmt.Fprintln(w, "no output within the start timeout of", sv.StartTimeout.String()+", stopping")
+			cancel()
+		}
+	}()
+	reached = func() bool {
+		select {
+		case <-expired:
+			return true
+		default:
+			return false
+		}
+	}
+	return reached, func() { close(released) }
+}
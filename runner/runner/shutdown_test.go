@@ -0,0 +1,64 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package runner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.ShutdownGracePeriod = time.Minute
+	r.Processes = []*ProcessType{
+		{Name: "polite", Cmd: []string{`trap 'touch "$PS.term"; exit 0' TERM; touch "$PS.up"; while true; do sleep 0.1; done`}},
+		{Name: "stubborn", Cmd: []string{`trap '' TERM; touch "$PS.up"; exec sleep 30`}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- r.Start(ctx) }()
+
+	polite, stubborn := filepath.Join(r.WorkDir, "polite.0"), filepath.Join(r.WorkDir, "stubborn.0")
+	if !eventually(t, func() bool { return fileExists(polite+".up") && fileExists(stubborn+".up") }) {
+		cancel()
+		<-errc
+		t.Fatal("processes did not start")
+	}
+
+	cancel()
+	if !eventually(t, func() bool { return fileExists(polite + ".term") }) {
+		t.Error("the first interrupt should have sent SIGTERM")
+	}
+	select {
+	case <-errc:
+		t.Fatal("runner should wait for the grace period while a process is still running")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	r.ForceStop()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second interrupt should have killed the remaining processes")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"sync"
+)
+
+// State is the lifecycle state of a process instance, as reported to
+// OnStateChange.
+type State string
+
+// Process instance states. The zero State describes instances that were not
+// started yet.
+const (
+	// Starting instances are waiting for their dependencies, or running
+	// their commands before the last one.
+	Starting State = "starting"
+	// Running instances have started their ReadyCommand (by default, the
+	// last command).
+	Running State = "running"
+	// Ready instances are running and ready for use. For process types
+	// with WaitForLog, it happens once the expression is matched;
+	// otherwise, it follows Running right away.
+	Ready State = "ready"
+	// Exited instances have all their commands finished.
+	Exited State = "exited"
+	// Restarting instances are about to be started again by the
+	// supervisor, after exiting.
+	Restarting State = "restarting"
+)
+
+type stateChange struct {
+	name     string
+	instance int
+	from, to State
+}
+
+// stateChanges keeps the state of each process instance, and the transitions
+// not delivered to OnStateChange yet.
+type stateChanges struct {
+	mu          sync.Mutex
+	states      map[string]State
+	pending     []stateChange
+	dispatching bool
+}
+
+// setState moves the instance of the process type into a new state, queueing
+// the transition for OnStateChange.
+func (r *Runner) setState(sv *ProcessType, instance int, to State) {
+	if r.OnStateChange == nil {
+		return
+	}
+	procName := sv.Name
+	if instance > -1 {
+		procName = fmt.Sprintf("%v.%v", procName, instance)
+	}
+	sc := &r.states
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.states == nil {
+		sc.states = make(map[string]State)
+	}
+	from := sc.states[procName]
+	if from == to {
+		return
+	}
+	sc.states[procName] = to
+	sc.pending = append(sc.pending, stateChange{sv.Name, instance, from, to})
+	if !sc.dispatching {
+		sc.dispatching = true
+		go r.dispatchStateChanges()
+	}
+}
+
+func (r *Runner) dispatchStateChanges() {
+	sc := &r.states
+	for {
+		sc.mu.Lock()
+		if len(sc.pending) == 0 {
+			sc.dispatching = false
+			sc.mu.Unlock()
+			return
+		}
+		change := sc.pending[0]
+		sc.pending = sc.pending[1:]
+		sc.mu.Unlock()
+		r.OnStateChange(change.name, change.instance, change.from, change.to)
+	}
+}
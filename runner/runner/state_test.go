@@ -0,0 +1,70 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestOnStateChange(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		transitions = make(map[string][]string)
+	)
+	r := New()
+	r.OnStateChange = func(name string, instance int, from, to State) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := fmt.Sprint(name, ".", instance)
+		transitions[key] = append(transitions[key], fmt.Sprint(from, "->", to))
+	}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"true"}},
+		{Name: "web", Cmd: []string{"sleep 0.1; exit 1"}, Restart: OnFailure},
+	}
+	recorded := func(key string, n int) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(transitions[key]) < n {
+			return nil
+		}
+		return append([]string(nil), transitions[key][:n]...)
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool { return recorded("web.0", 6) != nil })
+	stop()
+	if !ok {
+		t.Fatal("missing transitions:", transitions)
+	}
+
+	wantBuild := []string{"->starting", "starting->running", "running->exited"}
+	if got := recorded("build-web.-1", 3); !reflect.DeepEqual(got, wantBuild) {
+		t.Errorf("unexpected build transitions. got: %v, want: %v", got, wantBuild)
+	}
+	wantWeb := []string{
+		"->starting",
+		"starting->running",
+		"running->ready",
+		"ready->exited",
+		"exited->restarting",
+		"restarting->starting",
+	}
+	if got := recorded("web.0", 6); !reflect.DeepEqual(got, wantWeb) {
+		t.Errorf("unexpected web.0 transitions. got: %v, want: %v", got, wantWeb)
+	}
+}
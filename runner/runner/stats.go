@@ -0,0 +1,378 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// processStats is the bookkeeping of a process type instance across its
+// restarts.
+type processStats struct {
+	starts       int
+	lastExitCode int
+	uptime       time.Duration
+	startedAt    time.Time // zero when not running
+
+	// earlyExits is the number of consecutive runs that did not last the
+	// process type MinHealthyUptime.
+	earlyExits int
+
+	// restarts are when the instance was restarted, within the process
+	// type RestartWindow.
+	restarts []time.Time
+}
+
+// ExitTerminated is the exit code recorded for the processes that exited
+// because the runner stopped them (on shutdown, restarts, MaxRuntime or failed
+// health probes), as opposed to the ones that failed on their own. Processes
+// that handle the termination and exit cleanly record 0.
+const ExitTerminated = -2
+
+// ProcessStatus describes a process instance, or a build process type, as
+// recorded by the runner.
+type ProcessStatus struct {
+	// Name is the process name (e.g. "web.0" or "build-web").
+	Name string
+	// Starts is how many times the process was started.
+	Starts int
+	// Running tells whether the process is running.
+	Running bool
+	// LastExitCode is the exit code of the last run of the process: -1
+	// for processes terminated by signals on their own, and
+	// ExitTerminated for the ones stopped by the runner.
+	LastExitCode int
+	// Uptime is the time the process has been running, across its runs.
+	Uptime time.Duration
+	// Labels are the runner Labels. The map must not be modified.
+	Labels map[string]string
+	// Port is the IP port assigned to the process, zero for the build
+	// process types without WantPort.
+	Port int
+}
+
+// Status lists the processes started by the runner, ordered by name.
+func (r *Runner) Status() []ProcessStatus {
+	ports := make(map[string]int) // map of process name to its IP port
+	for _, inst := range r.plan() {
+		ports[inst.name] = inst.port
+	}
+	for _, proc := range r.processes() {
+		if pc := r.buildPortCount(proc); isBuild(proc) && pc > -1 {
+			ports[proc.Name] = r.basePort() + pc
+		}
+	}
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	statuses := make([]ProcessStatus, 0, len(r.stats))
+	for name, st := range r.stats {
+		status := ProcessStatus{
+			Name:         name,
+			Starts:       st.starts,
+			Running:      !st.startedAt.IsZero(),
+			LastExitCode: st.lastExitCode,
+			Uptime:       st.uptime,
+			Labels:       r.Labels,
+			Port:         ports[name],
+		}
+		if status.Running {
+			status.Uptime += r.since(st.startedAt)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// minRestartBackoff is the restart delay after the first early exit of an
+// instance. See ProcessType.MinHealthyUptime.
+const minRestartBackoff = 100 * time.Millisecond
+
+func (r *Runner) statsFor(procName string) *processStats {
+	if r.stats == nil {
+		r.stats = make(map[string]*processStats)
+	}
+	st, ok := r.stats[procName]
+	if !ok {
+		st = &processStats{}
+		r.stats[procName] = st
+	}
+	return st
+}
+
+func (r *Runner) recordStart(procName string) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	st := r.statsFor(procName)
+	st.starts++
+	st.startedAt = r.clock().Now()
+}
+
+func (r *Runner) recordExit(procName string, exitCode int) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	st := r.statsFor(procName)
+	st.lastExitCode = exitCode
+	if !st.startedAt.IsZero() {
+		st.uptime += r.since(st.startedAt)
+		st.startedAt = time.Time{}
+	}
+}
+
+// recordUptime accounts for how long a run of the instance lasted, before
+// exiting on its own.
+func (r *Runner) recordUptime(procName string, sv *ProcessType, uptime time.Duration) {
+	healthy := r.healthyUptime(sv)
+	if healthy <= 0 {
+		return
+	}
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	st := r.statsFor(procName)
+	if uptime >= healthy {
+		st.earlyExits = 0
+		return
+	}
+	st.earlyExits++
+}
+
+// healthyUptime is how long an instance of sv must stay up for its exit not
+// to be considered early: the MinHealthyUptime of the process type or, if not
+// set, the RestartBackoffMax of the runner. Zero means that exits are not
+// accounted for.
+func (r *Runner) healthyUptime(sv *ProcessType) time.Duration {
+	if sv.MinHealthyUptime > 0 {
+		return sv.MinHealthyUptime
+	}
+	if r.RestartBackoffMin > 0 {
+		return r.restartBackoffMax()
+	}
+	return 0
+}
+
+func (r *Runner) restartBackoffMax() time.Duration {
+	if r.RestartBackoffMax < r.RestartBackoffMin {
+		return r.RestartBackoffMin
+	}
+	return r.RestartBackoffMax
+}
+
+// restartBackoff is how long the instance must wait before being started,
+// given its consecutive early exits. exited reports whether it is restarted
+// after exiting on its own, rather than started by a rebuild. The
+// MinHealthyUptime of the process type takes precedence over the
+// RestartBackoffMin and RestartBackoffMax of the runner, which only delay the
+// restarts after exits.
+func (r *Runner) restartBackoff(procName string, sv *ProcessType, exited bool) time.Duration {
+	if sv.MinHealthyUptime <= 0 && r.RestartBackoffMin <= 0 {
+		return 0
+	}
+	r.statsMu.Lock()
+	earlyExits := r.statsFor(procName).earlyExits
+	r.statsMu.Unlock()
+	if sv.MinHealthyUptime > 0 {
+		if earlyExits == 0 {
+			return 0
+		}
+		return backoff(minRestartBackoff, sv.MinHealthyUptime, earlyExits-1)
+	}
+	if !exited {
+		return 0
+	}
+	if earlyExits > 0 {
+		earlyExits--
+	}
+	return jitter(backoff(r.RestartBackoffMin, r.restartBackoffMax(), earlyExits))
+}
+
+// backoff doubles min n times, up to max.
+func backoff(min, max time.Duration, n int) time.Duration {
+	delay := min
+	for i := 0; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// jitter shortens d by up to a fifth, at random, so the instances that fail
+// together do not restart in lockstep.
+func jitter(d time.Duration) time.Duration {
+	return d - time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// allowRestart records a restart of the instance, unless it exceeds the
+// MaxRestarts of the process type within its RestartWindow.
+func (r *Runner) allowRestart(procName string, sv *ProcessType) bool {
+	if sv.MaxRestarts <= 0 {
+		return true
+	}
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	st := r.statsFor(procName)
+	now := r.clock().Now()
+	if sv.RestartWindow > 0 {
+		recent := st.restarts[:0]
+		for _, t := range st.restarts {
+			if now.Sub(t) < sv.RestartWindow {
+				recent = append(recent, t)
+			}
+		}
+		st.restarts = recent
+	}
+	if len(st.restarts) >= sv.MaxRestarts {
+		return false
+	}
+	st.restarts = append(st.restarts, now)
+	return true
+}
+
+// restartRateWindow is the period over which MaxRestartRate is enforced.
+const restartRateWindow = time.Minute
+
+// restartRate tracks the restarts of all instances within the last
+// restartRateWindow.
+type restartRate struct {
+	mu       sync.Mutex
+	restarts []time.Time
+	paused   bool
+}
+
+// reserve records a restart, unless max restarts already happened within the
+// window. In that case, it returns how long until there is room for it. The
+// pauses and resumptions of the restarts are reported to w.
+func (rr *restartRate) reserve(w io.Writer, max int, now time.Time) time.Duration {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	recent := rr.restarts[:0]
+	for _, t := range rr.restarts {
+		if now.Sub(t) < restartRateWindow {
+			recent = append(recent, t)
+		}
+	}
+	rr.restarts = recent
+	if len(rr.restarts) < max {
+		rr.restarts = append(rr.restarts, now)
+		if rr.paused {
+			rr.paused = false
+			fmt.Fprintln(w, "restart rate back under", max, "per minute, resuming restarts")
+		}
+		return 0
+	}
+	if !rr.paused {
+		rr.paused = true
+		fmt.Fprintf(w, "WARNING: processes restarted more than %d times in the last minute, pausing all restarts\n", max)
+	}
+	return rr.restarts[0].Add(restartRateWindow).Sub(now)
+}
+
+// waitRestartRate holds a restart while the runner exceeds its MaxRestartRate.
+// It returns false if ctx is done first.
+func (r *Runner) waitRestartRate(ctx context.Context) bool {
+	if r.MaxRestartRate <= 0 {
+		return true
+	}
+	for {
+		delay := r.restartRate.reserve(r.metaOutput(), r.MaxRestartRate, r.clock().Now())
+		if delay <= 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.clock().After(delay):
+		}
+	}
+}
+
+func (r *Runner) resetRestarts(procName string) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.statsFor(procName).restarts = nil
+}
+
+func (r *Runner) recordBuild(ok bool) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.lastBuildOK, r.lastBuildAt = ok, r.clock().Now()
+}
+
+// LastBuildStatus reports whether the most recent run of the build process
+// types succeeded, and when it finished. If no build ran yet, when is zero.
+func (r *Runner) LastBuildStatus() (ok bool, when time.Time) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	return r.lastBuildOK, r.lastBuildAt
+}
+
+// exitCode extracts the exit code of a command from the error returned when
+// running it. Processes terminated by signals report -1.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) writeSummary(w io.Writer) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	names := make([]string, 0, len(r.stats))
+	for name := range r.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "summary:")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "process\trestarts\tlast exit code\tuptime")
+	for _, name := range names {
+		st := r.stats[name]
+		uptime := st.uptime
+		lastExitCode := fmt.Sprint(st.lastExitCode)
+		if st.lastExitCode == ExitTerminated {
+			lastExitCode = "terminated"
+		}
+		if !st.startedAt.IsZero() {
+			uptime += r.since(st.startedAt)
+			lastExitCode = "running"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", name, st.starts-1, lastExitCode, uptime.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,425 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"true"}},
+		{Name: "web", Cmd: []string{"sleep 0.05; exit 3"}, Restart: Always},
+	}
+	stop := startRunner(t, &r)
+	ok := eventually(t, func() bool {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		st, ok := r.stats["web.0"]
+		return ok && st.starts > 3
+	})
+	stop()
+	if !ok {
+		t.Fatal("web.0 did not restart")
+	}
+
+	var buf bytes.Buffer
+	r.writeSummary(&buf)
+	t.Log(buf.String())
+
+	if !regexp.MustCompile(`(?m)^build-web\s+0\s+0\s`).Match(buf.Bytes()) {
+		t.Error("build-web missing from the summary")
+	}
+	m := regexp.MustCompile(`(?m)^web\.0\s+(\d+)\s+(3|terminated)\s`).FindSubmatch(buf.Bytes())
+	if m == nil {
+		t.Fatal("web.0 missing from the summary")
+	}
+	if restarts, _ := strconv.Atoi(string(m[1])); restarts < 3 {
+		t.Error("unexpected restart count for web.0:", restarts)
+	}
+}
+
+func TestRestartExitCodes(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "tempfail", Cmd: []string{"exit 75"}, Group: "a", Restart: Always, RestartExitCodes: []int{75}},
+		{Name: "usage", Cmd: []string{"exit 2"}, Group: "b", Restart: Always, RestartExitCodes: []int{75}},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	if !eventually(t, func() bool { return starts("tempfail.0") > 2 }) {
+		t.Error("process exiting with 75 should have been restarted")
+	}
+	if n := starts("usage.0"); n != 1 {
+		t.Error("process exiting with 2 should not have been restarted, starts:", n)
+	}
+}
+
+func TestLastBuildStatus(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.Observables = []string{"*.txt"}
+	trigger := filepath.Join(r.WorkDir, "trigger.txt")
+	if err := ioutil.WriteFile(trigger, []byte("ok\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"! grep -q fail trigger.txt"}},
+		{Name: "web", Cmd: []string{"exec sleep 30"}},
+	}
+	if _, when := r.LastBuildStatus(); !when.IsZero() {
+		t.Fatal("no build should have been reported before the start")
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { ok, when := r.LastBuildStatus(); return ok && !when.IsZero() }) {
+		t.Fatal("the first build should have succeeded")
+	}
+	_, firstBuild := r.LastBuildStatus()
+	if err := ioutil.WriteFile(trigger, []byte("fail\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(t, func() bool { ok, when := r.LastBuildStatus(); return !ok && when.After(firstBuild) }) {
+		t.Error("the status should flip to false after a failing build")
+	}
+}
+
+func TestMinHealthyUptime(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	sv := &ProcessType{
+		Name:             "web",
+		Cmd:              []string{"test -f healthy && sleep 0.5; exit 1"},
+		Restart:          OnFailure,
+		MinHealthyUptime: 400 * time.Millisecond,
+	}
+	r.Processes = []*ProcessType{sv}
+	earlyExits := func() int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats["web.0"]; ok {
+			return st.earlyExits
+		}
+		return 0
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return earlyExits() >= 3 }) {
+		t.Fatal("early exits not accounted for")
+	}
+	if delay := r.restartBackoff("web.0", sv, true); delay < 2*minRestartBackoff || delay > sv.MinHealthyUptime {
+		t.Error("unexpected restart delay after consecutive early exits:", delay)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(r.WorkDir, "healthy"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(t, func() bool { return earlyExits() == 0 }) {
+		t.Fatal("surviving MinHealthyUptime should reset the early exits")
+	}
+	if delay := r.restartBackoff("web.0", sv, true); delay != 0 {
+		t.Error("unexpected restart delay after a healthy run:", delay)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	clock := newFakeClock()
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.timeSource = clock
+	r.RestartBackoffMin = time.Second
+	r.RestartBackoffMax = 4 * time.Second
+	sv := &ProcessType{Name: "web", Cmd: []string{"exit 1"}, Restart: OnFailure}
+	r.Processes = []*ProcessType{sv}
+	starts := func() int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats["web.0"]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	delayRe := regexp.MustCompile(`web\.0 exited, restarting in (\S+)`)
+	delays := func() []time.Duration {
+		var delays []time.Duration
+		for _, m := range delayRe.FindAllStringSubmatch(buf.String(), -1) {
+			d, err := time.ParseDuration(m[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			delays = append(delays, d)
+		}
+		return delays
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if !eventually(t, func() bool { return len(delays()) > i }) {
+			t.Fatal("missing restart delay #", i+1, buf.String())
+		}
+		delay := delays()[i]
+		if delay < want*4/5 || delay > want {
+			t.Errorf("restart #%d: unexpected delay %v, want between %v and %v", i+1, delay, want*4/5, want)
+		}
+		if n := starts(); n != i+1 {
+			t.Fatalf("restart #%d: the instance should wait for the delay, starts: %d", i+1, n)
+		}
+		clock.Advance(delay)
+		if !eventually(t, func() bool { return starts() == i+2 }) {
+			t.Fatalf("restart #%d: the instance did not restart after the delay", i+1)
+		}
+	}
+
+	r.recordUptime("web.0", sv, r.RestartBackoffMax)
+	if delay := r.restartBackoff("web.0", sv, true); delay < r.RestartBackoffMin*4/5 || delay > r.RestartBackoffMin {
+		t.Error("staying up for RestartBackoffMax should reset the delay, got:", delay)
+	}
+}
+
+func TestRestartWindow(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{
+		{
+			Name:          "within",
+			Cmd:           []string{"exit 1"},
+			Group:         "a",
+			Restart:       OnFailure,
+			MaxRestarts:   2,
+			RestartWindow: time.Minute,
+		},
+		{
+			Name:          "spread",
+			Cmd:           []string{"sleep 0.2; exit 1"},
+			Group:         "b",
+			Restart:       OnFailure,
+			MaxRestarts:   2,
+			RestartWindow: 300 * time.Millisecond,
+		},
+	}
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return starts("spread.0") > 4 }) {
+		t.Error("failures spread across the window should not exhaust the restarts")
+	}
+	if n := starts("within.0"); n != 3 {
+		t.Error("failures within the window should stop after 2 restarts, starts:", n)
+	}
+}
+
+func TestMaxRestartsGivingUp(t *testing.T) {
+	var buf syncBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	r := New()
+	r.Processes = []*ProcessType{
+		{Name: "flaky", Cmd: []string{"exit 1"}, Group: "a", Restart: OnFailure, MaxRestarts: 1},
+		{Name: "steady", Cmd: []string{"sleep 0.2; exit 1"}, Group: "a", Restart: OnFailure},
+	}
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return strings.Contains(buf.String(), "giving up on flaky.0") }) {
+		t.Fatal("the runner should have given up on flaky.0:", buf.String())
+	}
+	n := starts("flaky.0")
+	if !eventually(t, func() bool { return starts("steady.0") > 3 }) {
+		t.Fatal("steady should keep restarting")
+	}
+	if got := starts("flaky.0"); got != n {
+		t.Errorf("flaky.0 should not start again once given up on, starts: %d, then %d", n, got)
+	}
+	if c := strings.Count(buf.String(), "giving up on flaky.0"); c != 1 {
+		t.Errorf("the runner should give up on flaky.0 once, got %d times", c)
+	}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	r := New()
+	var out syncBuffer
+	r.MetaOutput = &out
+	r.Processes = []*ProcessType{
+		{Name: "timeout", Cmd: []string{"exec sleep 30"}, Group: "a", MaxRuntime: 200 * time.Millisecond},
+		{Name: "onfailure", Cmd: []string{"exec sleep 30"}, Group: "b", Restart: OnFailure, MaxRuntime: 200 * time.Millisecond},
+		{Name: "recycled", Cmd: []string{"exec sleep 30"}, Group: "c", Restart: Always, MaxRuntime: 100 * time.Millisecond, MaxRestarts: 1},
+	}
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return starts("recycled.0") > 3 }) {
+		t.Error("instances stopped by MaxRuntime should be recycled, regardless of MaxRestarts")
+	}
+	for _, name := range []string{"timeout.0", "onfailure.0"} {
+		if n := starts(name); n != 1 {
+			t.Errorf("%s: instances stopped by MaxRuntime should not be restarted, starts: %d", name, n)
+		}
+	}
+	if !strings.Contains(out.String(), "maximum runtime of 200ms reached, stopping") {
+		t.Error("missing message about the maximum runtime")
+	}
+}
+
+func TestStatusExitCodes(t *testing.T) {
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.ShutdownGracePeriod = 5 * time.Second
+	r.Processes = []*ProcessType{
+		{Name: "crash", Cmd: []string{"exit 7"}},
+		{Name: "stopped", Cmd: []string{`touch "$PS.up"; exec sleep 30`}},
+		{Name: "graceful", Cmd: []string{`trap 'exit 0' TERM; touch "$PS.up"; while true; do sleep 0.05; done`}},
+	}
+	status := func() map[string]ProcessStatus {
+		statuses := make(map[string]ProcessStatus)
+		for _, st := range r.Status() {
+			statuses[st.Name] = st
+		}
+		return statuses
+	}
+	stop := startRunner(t, &r)
+	if !eventually(t, func() bool {
+		st := status()
+		return st["crash.0"].Starts == 1 && !st["crash.0"].Running &&
+			fileExists(filepath.Join(r.WorkDir, "stopped.0.up")) &&
+			fileExists(filepath.Join(r.WorkDir, "graceful.0.up"))
+	}) {
+		stop()
+		t.Fatalf("processes did not start: %+v", status())
+	}
+	stop()
+
+	want := map[string]int{"crash.0": 7, "stopped.0": ExitTerminated, "graceful.0": 0}
+	for name, st := range status() {
+		if st.Running {
+			t.Errorf("%s: should not be running after the stop", name)
+		}
+		if st.LastExitCode != want[name] {
+			t.Errorf("%s: unexpected exit code %d, want %d", name, st.LastExitCode, want[name])
+		}
+	}
+}
+
+func TestMaxRestartRate(t *testing.T) {
+	r := New()
+	r.MaxRestartRate = 3
+	r.Processes = []*ProcessType{
+		{Name: "a", Cmd: []string{"exit 1"}, Group: "a", Restart: OnFailure},
+		{Name: "b", Cmd: []string{"exit 1"}, Group: "b", Restart: OnFailure},
+	}
+	starts := func() int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		n := 0
+		for _, st := range r.stats {
+			n += st.starts
+		}
+		return n
+	}
+	paused := func() bool {
+		r.restartRate.mu.Lock()
+		defer r.restartRate.mu.Unlock()
+		return r.restartRate.paused
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, paused) {
+		t.Fatal("restarts should have been paused")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := starts(); n != 2+r.MaxRestartRate {
+		t.Error("unexpected starts once the restarts are paused:", n)
+	}
+}
+
+func TestStartTimeout(t *testing.T) {
+	r := New()
+	var out syncBuffer
+	r.MetaOutput = &out
+	r.Processes = []*ProcessType{
+		{Name: "hung", Cmd: []string{"exec sleep 30"}, Group: "a", Restart: OnFailure, StartTimeout: 200 * time.Millisecond},
+		{Name: "slow", Cmd: []string{"sleep 0.1; echo started; exec sleep 30"}, Group: "b", Restart: OnFailure, StartTimeout: time.Second},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	status := func() map[string]ProcessStatus {
+		statuses := make(map[string]ProcessStatus)
+		for _, st := range r.Status() {
+			statuses[st.Name] = st
+		}
+		return statuses
+	}
+	if !eventually(t, func() bool { return status()["hung.0"].Starts > 1 }) {
+		t.Fatal("instances stopped by the start timeout should count as failures and be restarted")
+	}
+	if !strings.Contains(out.String(), "no output within the start timeout of 200ms, stopping") {
+		t.Error("missing message about the start timeout")
+	}
+	time.Sleep(time.Second)
+	if st := status()["slow.0"]; st.Starts != 1 || !st.Running {
+		t.Errorf("instances with output before the start timeout should keep running: %+v", st)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"sort"
+)
+
+// GroupStrategy defines what happens to the process instances of a group when
+// one of them exits and is going to be restarted.
+type GroupStrategy string
+
+// Types of GroupStrategy.
+const (
+	// OneForAll stops all the process instances of the group and starts
+	// them again, including the ones that are not otherwise restarted. It
+	// is the default strategy.
+	OneForAll GroupStrategy = "one-for-all"
+	// OneForOne restarts only the process instance that exited.
+	OneForOne GroupStrategy = "one-for-one"
+)
+
+// groupStrategy returns the strategy of the group, see GroupStrategies.
+func (r *Runner) groupStrategy(group string) GroupStrategy {
+	if strategy, ok := r.GroupStrategies[group]; ok && strategy != "" {
+		return strategy
+	}
+	return OneForAll
+}
+
+func (r *Runner) validateGroupStrategies(procs []*ProcessType) error {
+	groups := map[string]bool{"": true}
+	for _, proc := range procs {
+		groups[proc.Group] = true
+	}
+	names := make([]string, 0, len(r.GroupStrategies))
+	for name := range r.GroupStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !groups[name] {
+			return fmt.Errorf("group strategies: unknown group %q", name)
+		}
+		switch strategy := r.GroupStrategies[name]; strategy {
+		case "", OneForAll, OneForOne:
+		default:
+			return fmt.Errorf("group strategies: %q has an unknown strategy %q", name, strategy)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupStrategies(t *testing.T) {
+	r := New()
+	r.GroupStrategies = map[string]GroupStrategy{"a": OneForAll, "b": OneForOne}
+	r.Processes = []*ProcessType{
+		{Name: "crash-a", Cmd: []string{"sleep 0.2; exit 1"}, Group: "a", Restart: OnFailure},
+		{Name: "sibling-a", Cmd: []string{"exec sleep 30"}, Group: "a"},
+		{Name: "crash-b", Cmd: []string{"sleep 0.2; exit 1"}, Group: "b", Restart: OnFailure},
+		{Name: "sibling-b", Cmd: []string{"exec sleep 30"}, Group: "b"},
+	}
+	starts := func(name string) int {
+		r.statsMu.Lock()
+		defer r.statsMu.Unlock()
+		if st, ok := r.stats[name]; ok {
+			return st.starts
+		}
+		return 0
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	if !eventually(t, func() bool { return starts("sibling-a.0") > 1 }) {
+		t.Error("one-for-all groups should restart the siblings of the process that crashed")
+	}
+	if !eventually(t, func() bool { return starts("crash-b.0") > 2 }) {
+		t.Fatal("crash-b should have been restarted")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := starts("sibling-b.0"); n != 1 {
+		t.Error("one-for-one groups should not restart the siblings of the process that crashed, starts:", n)
+	}
+}
+
+func TestValidateGroupStrategies(t *testing.T) {
+	tests := []struct {
+		strategies map[string]GroupStrategy
+		want       string
+	}{
+		{map[string]GroupStrategy{"": OneForOne, "web": OneForAll}, ""},
+		{map[string]GroupStrategy{"db": OneForOne}, `group strategies: unknown group "db"`},
+		{map[string]GroupStrategy{"web": "rest-for-one"}, `group strategies: "web" has an unknown strategy "rest-for-one"`},
+	}
+	for _, tt := range tests {
+		r := New()
+		r.GroupStrategies = tt.strategies
+		r.Processes = []*ProcessType{{Name: "web", Cmd: []string{"./web"}, Group: "web"}}
+		var got string
+		if err := r.Validate(); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("unexpected error for %v: %q, want: %q", tt.strategies, got, tt.want)
+		}
+	}
+}
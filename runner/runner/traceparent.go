@@ -0,0 +1,44 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+type traceParentKey struct{}
+
+// withTraceParent attaches to ctx a new W3C trace context, shared by the
+// processes of a generation through the TRACEPARENT environment variable, so
+// the spans they create are correlated under a single trace.
+func withTraceParent(ctx context.Context) context.Context {
+	traceID, spanID := make([]byte, 16), make([]byte, 8)
+	if _, err := rand.Read(traceID); err != nil {
+		return ctx
+	}
+	if _, err := rand.Read(spanID); err != nil {
+		return ctx
+	}
+	traceParent := fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(traceID), hex.EncodeToString(spanID))
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+func traceParentFrom(ctx context.Context) string {
+	traceParent, _ := ctx.Value(traceParentKey{}).(string)
+	return traceParent
+}
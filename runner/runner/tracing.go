@@ -0,0 +1,67 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import "context"
+
+// Tracer creates the spans that describe the runner activity: a root span for
+// each generation of processes, and child spans for the build phase, each
+// process start and each readiness wait. Its method set mirrors
+// OpenTelemetry's trace.Tracer and trace.Span, so an OpenTelemetry tracer can
+// be plugged in with a thin adapter.
+type Tracer interface {
+	// Start creates a span, child of the span carried by ctx, if any.
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	RecordError(err error)
+	End()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) RecordError(error) {}
+func (noopSpan) End()              {}
+
+func (r *Runner) tracer() Tracer {
+	if r.Tracer == nil {
+		return noopTracer{}
+	}
+	return r.Tracer
+}
+
+// valuesContext takes the values of one context, and the cancellation of
+// another. It allows contexts created for cancellation purposes to belong to
+// the trace of a generation of processes.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func withValues(ctx, values context.Context) context.Context {
+	return valuesContext{Context: ctx, values: values}
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
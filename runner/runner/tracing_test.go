@@ -0,0 +1,117 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	ended  bool
+	err    error
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	s := &recordedSpan{name: name, parent: parent}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), &recordingSpan{t, s}
+}
+
+func (t *recordingTracer) find(name string) (recordedSpan, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return *s, true
+		}
+	}
+	return recordedSpan{}, false
+}
+
+type recordingSpan struct {
+	t *recordingTracer
+	s *recordedSpan
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.t.mu.Lock()
+	s.s.err = err
+	s.t.mu.Unlock()
+}
+
+func (s *recordingSpan) End() {
+	s.t.mu.Lock()
+	s.s.ended = true
+	s.t.mu.Unlock()
+}
+
+func TestTracer(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tracer := &recordingTracer{}
+	r := New()
+	r.Tracer = tracer
+	r.Processes = []*ProcessType{
+		{Name: "build-web", Cmd: []string{"true"}},
+		{Name: "web", Cmd: []string{"true"}, WaitFor: l.Addr().String()},
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	ok := eventually(t, func() bool {
+		s, ok := tracer.find("process web.0")
+		return ok && s.ended
+	})
+	if !ok {
+		t.Fatal("web.0 span not found")
+	}
+
+	build, ok := tracer.find("process build-web")
+	if !ok {
+		t.Fatal("build-web span not found")
+	}
+	if !build.ended || build.parent == nil || build.parent.name != "builds" ||
+		build.parent.parent == nil || build.parent.parent.name != "generation" {
+		t.Errorf("unexpected build span: %+v", build)
+	}
+
+	wait, ok := tracer.find("waitfor " + l.Addr().String())
+	if !ok {
+		t.Fatal("waitfor span not found")
+	}
+	if !wait.ended || wait.parent == nil || wait.parent.name != "process web.0" ||
+		wait.parent.parent == nil || wait.parent.parent.name != "generation" {
+		t.Errorf("unexpected waitfor span: %+v", wait)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// warmRestartTimeout is how long the instance of a process type with
+// WarmRestart is kept running, after a restart is triggered, waiting for its
+// replacement to be ready.
+const warmRestartTimeout = 30 * time.Second
+
+// warmRun is a run of an instance of a process type with WarmRestart.
+type warmRun struct {
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func (run *warmRun) markReady() {
+	run.readyOnce.Do(func() { close(run.ready) })
+}
+
+// warmRuns tracks the latest run of each instance of the process types with
+// WarmRestart.
+type warmRuns struct {
+	mu     sync.Mutex
+	latest map[string]*warmRun // map of process name to its latest run
+}
+
+// begin registers a new run of the instance, superseding the previous one.
+func (w *warmRuns) begin(procName string) *warmRun {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.latest == nil {
+		w.latest = make(map[string]*warmRun)
+	}
+	run := &warmRun{ready: make(chan struct{})}
+	w.latest[procName] = run
+	return run
+}
+
+// superseded reports whether a newer run of the instance was started after
+// run. It is always false for instances without WarmRestart, whose run is
+// nil.
+func (w *warmRuns) superseded(procName string, run *warmRun) bool {
+	if run == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.latest[procName] != run
+}
+
+// successor returns the latest run of the instance, if newer than run.
+func (w *warmRuns) successor(procName string, run *warmRun) (*warmRun, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	latest, ok := w.latest[procName]
+	return latest, ok && latest != run
+}
+
+// warmHandoff derives from cmdCtx the context that stops a run of an instance
+// with WarmRestart. When ctx is cancelled, as on the restarts triggered by
+// file changes, the context is only cancelled once the replacement of the
+// instance is ready, or after warmRestartTimeout. The other stops, like the
+// runner's or the ones by MaxRuntime, are immediate. release must be called
+// once the run exits.
+func (r *Runner) warmHandoff(ctx, cmdCtx context.Context, w io.Writer, procName string, run *warmRun) (_ context.Context, release func()) {
+	if run == nil {
+		return cmdCtx, func() {}
+	}
+	handoffCtx, cancel := context.WithCancel(context.Background())
+	stopping, _ := r.shutdown.channels()
+	go func() {
+		defer cancel()
+		select {
+		case <-handoffCtx.Done():
+			return
+		case <-cmdCtx.Done():
+		}
+		if ctx.Err() == nil || r.shutdown.isStopping() {
+			return
+		}
+		fmt.Fprintln(w, "waiting for the replacement to be ready before stopping")
+		timeout := r.clock().NewTimer(warmRestartTimeout)
+		defer timeout.Stop()
+		for {
+			var ready <-chan struct{}
+			if next, ok := r.warmRuns.successor(procName, run); ok {
+				ready = next.ready
+			}
+			select {
+			case <-ready:
+				return
+			case <-timeout.C():
+				fmt.Fprintln(w, "replacement not ready after", warmRestartTimeout, "stopping anyway")
+				return
+			case <-stopping:
+				return
+			case <-handoffCtx.Done():
+				return
+			case <-r.clock().After(50 * time.Millisecond):
+			}
+		}
+	}()
+	return handoffCtx, cancel
+}
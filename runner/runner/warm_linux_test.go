@@ -0,0 +1,197 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestWarmRestartServer is the application started by TestWarmRestart: it
+// serves its RUN_ID on $PORT, recording in the file named by
+// RUNNER_WARM_SERVER when it starts listening and when it is stopped.
+func TestWarmRestartServer(t *testing.T) {
+	events := os.Getenv("RUNNER_WARM_SERVER")
+	if events == "" {
+		t.Skip("application started by TestWarmRestart")
+	}
+	record := func(event string) {
+		f, err := os.OpenFile(events, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		fmt.Fprintln(f, event, os.Getenv("RUN_ID"))
+	}
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		if os.Getenv("SO_REUSEPORT") != "1" {
+			return nil
+		}
+		var err error
+		c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		return err
+	}}
+	l, err := lc.Listen(context.Background(), "tcp", "localhost:"+os.Getenv("PORT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record("listening")
+	fmt.Println("accepting connections")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM)
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getenv("RUN_ID"))
+	}))
+	<-stop
+	l.Close()
+	record("stopped")
+	os.Exit(0)
+}
+
+func TestWarmRestart(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	r.WorkDir = tempDir(t)
+	r.BasePort = 65400
+	r.ShutdownGracePeriod = 5 * time.Second
+	r.Observables = []string{"*.txt"}
+	events := filepath.Join(r.WorkDir, "events")
+	r.Processes = []*ProcessType{{
+		Name:        "web",
+		Cmd:         []string{fmt.Sprintf("RUNNER_WARM_SERVER=%s exec %s -test.run=TestWarmRestartServer", events, exe)},
+		Restart:     Always,
+		WaitForLog:  "accepting connections",
+		WarmRestart: true,
+	}}
+	trigger := filepath.Join(r.WorkDir, "trigger.txt")
+	if err := ioutil.WriteFile(trigger, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stop := startRunner(t, &r)
+	defer stop()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() (string, error) {
+		resp, err := client.Get("http://localhost:65400/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+	var first string
+	if !eventually(t, func() bool {
+		first, err = get()
+		return err == nil
+	}) {
+		t.Fatal("the first instance did not start:", err)
+	}
+
+	// Connections queued on the listener of the stopped instance may be
+	// reset, as the application does not drain them, but none should be
+	// refused for the lack of a listener.
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		refused int
+		done    = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := get(); errors.Is(err, syscall.ECONNREFUSED) {
+				mu.Lock()
+				refused++
+				mu.Unlock()
+			}
+		}
+	}()
+	if err := ioutil.WriteFile(trigger, []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	ok := eventually(t, func() bool {
+		b, _ := ioutil.ReadFile(events)
+		lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+		return strings.Contains(string(b), "stopped "+first)
+	})
+	close(done)
+	wg.Wait()
+	if !ok {
+		t.Fatalf("the instance was not replaced: %q", lines)
+	}
+
+	// File changes may trigger more than one restart, so each stopped
+	// instance is checked against the instances started after it.
+	listening := make(map[string]int) // map of RUN_ID to its line
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		switch event, runID := fields[0], fields[1]; event {
+		case "listening":
+			listening[runID] = i
+		case "stopped":
+			replaced := false
+			for other, at := range listening {
+				replaced = replaced || other != runID && at > listening[runID]
+			}
+			if !replaced {
+				t.Errorf("%s was stopped before its replacement was listening: %q", runID, lines)
+			}
+		}
+	}
+	if refused > 0 {
+		t.Errorf("%d connections refused during the restart", refused)
+	}
+	latest := func() string {
+		b, _ := ioutil.ReadFile(events)
+		runID := ""
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasPrefix(line, "listening ") {
+				runID = strings.TrimPrefix(line, "listening ")
+			}
+		}
+		return runID
+	}
+	if !eventually(t, func() bool { got, err := get(); return err == nil && got == latest() }) {
+		t.Error("the replacement should be serving")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"cirello.io/svc/pkg/jwt"
@@ -55,8 +56,78 @@ func stripPort(hostport string) string {
 	return net.JoinHostPort(host, "443")
 }
 
-func handleSSOLogin(svcName string, caPEM []byte, w http.ResponseWriter, r *http.Request) {
-	if _, ok := acceptableTargets[r.Host]; !ok {
+// ssoTarget is the single sign-on configuration of a host behind the gateway.
+type ssoTarget struct {
+	// ClientID is the application ID registered with the identity
+	// provider. If empty, googleClientID is used.
+	ClientID string
+	// Provider is the identity provider that validates the logins. If
+	// empty, "google" is used (see tokenInfoURLs).
+	Provider string
+	// AllowedDomains restricts the logins to the emails of these domains.
+	// If empty, any email is accepted.
+	AllowedDomains []string
+}
+
+// tokenInfoURLs are the endpoints of the supported identity providers that
+// validate the ID tokens.
+var tokenInfoURLs = map[string]string{
+	"google": "https://www.googleapis.com/oauth2/v3/tokeninfo",
+}
+
+func (t ssoTarget) clientID() string {
+	if t.ClientID == "" {
+		return googleClientID
+	}
+	return t.ClientID
+}
+
+func (t ssoTarget) tokenInfoURL() (string, bool) {
+	provider := t.Provider
+	if provider == "" {
+		provider = "google"
+	}
+	u, ok := tokenInfoURLs[provider]
+	return u, ok
+}
+
+func (t ssoTarget) allowsEmail(email string) bool {
+	if len(t.AllowedDomains) == 0 {
+		return true
+	}
+	for _, domain := range t.AllowedDomains {
+		if strings.HasSuffix(strings.ToLower(email), "@"+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validRedirect tells whether the user can be sent back to the given URL after
+// logging in: either a path in the same host, or a URL of one of the
+// acceptableTargets. It prevents the gateway from being used as an open
+// redirect.
+func validRedirect(raw string) bool {
+	if strings.Contains(raw, "\\") {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Opaque != "" || u.User != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	_, ok := acceptableTargets[u.Host]
+	return ok
+}
+
+func handleSSOLogin(svcName string, keys *jwt.KeySet, w http.ResponseWriter, r *http.Request) {
+	target, ok := acceptableTargets[r.Host]
+	if !ok {
 		log.Println("invalid target:", r.Host)
 		http.Error(w, http.StatusText(http.StatusUnauthorized),
 			http.StatusUnauthorized)
@@ -65,41 +136,93 @@ func handleSSOLogin(svcName string, caPEM []byte, w http.ResponseWriter, r *http
 
 	switch r.RequestURI {
 	case "/ssoLogin":
+		ip := remoteIP(r)
+		if ssoLockout.locked(ip) {
+			log.Println("too many invalid logins from:", ip)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests),
+				http.StatusTooManyRequests)
+			return
+		}
+
 		if err := r.ParseForm(); err != nil {
 			log.Println("cannot read form:", err)
+			ssoLockout.fail(ip)
+			http.Error(w, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
+
+		redirect := r.FormValue("redirect")
+		if redirect != "" && !validRedirect(redirect) {
+			log.Println("invalid redirect:", redirect)
+			http.Error(w, http.StatusText(http.StatusBadRequest),
+				http.StatusBadRequest)
+			return
+		}
+
+		tokenInfoURL, ok := target.tokenInfoURL()
+		if !ok {
+			log.Println("unknown identity provider:", target.Provider)
 			http.Error(w, http.StatusText(http.StatusUnauthorized),
 				http.StatusUnauthorized)
 			return
 		}
 
 		idToken := url.QueryEscape(r.FormValue("id_token"))
-		resp, err := http.Get("https://www.googleapis.com/oauth2/v3/tokeninfo?id_token=" + idToken)
+		resp, err := http.Get(tokenInfoURL + "?id_token=" + idToken)
 		if err != nil {
 			log.Println("cannot validate token:", err)
 			http.Error(w, http.StatusText(http.StatusUnauthorized),
 				http.StatusUnauthorized)
 			return
 		}
+		defer resp.Body.Close()
 
 		var tokenValidation struct {
 			AUD   string `json:"aud"`
 			Email string `json:"email"`
+			Name  string `json:"name"`
+			HD    string `json:"hd"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&tokenValidation); err != nil {
 			log.Println("cannot parse token validation response:", err)
+			ssoLockout.fail(ip)
 			http.Error(w, http.StatusText(http.StatusUnauthorized),
 				http.StatusUnauthorized)
 			return
 		}
 
-		if tokenValidation.AUD != googleClientID {
+		if tokenValidation.AUD != target.clientID() {
 			log.Println("invalid application ID, got:", tokenValidation.AUD)
+			ssoLockout.fail(ip, tokenValidation.Email)
 			http.Error(w, http.StatusText(http.StatusUnauthorized),
 				http.StatusUnauthorized)
 			return
 		}
 
-		rawToken, err := jwt.CreateFromEmail(svcName, caPEM, tokenValidation.Email, 1*time.Hour)
+		if ssoLockout.locked(tokenValidation.Email) {
+			log.Println("too many invalid logins for:", tokenValidation.Email)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests),
+				http.StatusTooManyRequests)
+			return
+		}
+
+		if !target.allowsEmail(tokenValidation.Email) {
+			log.Println("email not allowed for", r.Host+":", tokenValidation.Email)
+			ssoLockout.fail(ip, tokenValidation.Email)
+			http.Error(w, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
+
+		extra := make(map[string]interface{})
+		if tokenValidation.Name != "" {
+			extra["name"] = tokenValidation.Name
+		}
+		if tokenValidation.HD != "" {
+			extra["hostedDomain"] = tokenValidation.HD
+		}
+		rawToken, err := keys.CreateFromEmailWithClaims(svcName, tokenValidation.Email, extra, 1*time.Hour)
 		if err != nil {
 			log.Println("cannot parse token validation response:", err)
 			http.Error(w, http.StatusText(http.StatusUnauthorized),
@@ -107,14 +230,18 @@ func handleSSOLogin(svcName string, caPEM []byte, w http.ResponseWriter, r *http
 			return
 		}
 
+		ssoLockout.reset(ip, tokenValidation.Email)
 		http.SetCookie(w, &http.Cookie{
 			Name:  gatewayTokenCookie,
 			Value: rawToken,
 		})
+		if redirect != "" {
+			w.Header().Set(ssoRedirectHeader, redirect)
+		}
 		fmt.Fprintln(w, tokenValidation.Email)
 
 	default:
 		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprintf(w, ssoHTML, googleClientID)
+		fmt.Fprintf(w, ssoHTML, target.clientID())
 	}
 }
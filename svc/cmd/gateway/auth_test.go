@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"cirello.io/svc/pkg/jwt"
+)
+
+// fakeTokenInfo replaces the Google token validation endpoint with one that
+// accepts the ID tokens formatted as "audience:email".
+func fakeTokenInfo(t *testing.T) func() {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(r.URL.Query().Get("id_token"), ":", 2)
+		if len(parts) != 2 {
+			http.Error(w, "invalid token", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"aud": parts[0], "email": parts[1]})
+	}))
+	original := tokenInfoURLs["google"]
+	tokenInfoURLs["google"] = server.URL
+	return func() {
+		tokenInfoURLs["google"] = original
+		server.Close()
+	}
+}
+
+func setTargets(targets map[string]ssoTarget) func() {
+	original := acceptableTargets
+	acceptableTargets = targets
+	return func() { acceptableTargets = original }
+}
+
+func ssoLogin(host, idToken string) *httptest.ResponseRecorder {
+	return ssoLoginForm(host, url.Values{"id_token": {idToken}})
+}
+
+func ssoLoginForm(host string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/ssoLogin", strings.NewReader(form.Encode()))
+	r.Host = host
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSSOLogin(host, jwt.NewKeySet([]byte("secret")), w, r)
+	return w
+}
+
+func TestSSOLoginPerHost(t *testing.T) {
+	defer fakeTokenInfo(t)()
+	original := ssoLockout
+	ssoLockout = &lockout{}
+	defer func() { ssoLockout = original }()
+	defer setTargets(map[string]ssoTarget{
+		"a.example.com": {ClientID: "client-a"},
+		"b.example.com": {ClientID: "client-b", AllowedDomains: []string{"example.com"}},
+	})()
+
+	tests := []struct {
+		host, idToken string
+		want          int
+	}{
+		{"a.example.com", "client-a:u@example.com", http.StatusOK},
+		{"a.example.com", "client-b:u@example.com", http.StatusUnauthorized},
+		{"b.example.com", "client-b:u@example.com", http.StatusOK},
+		{"b.example.com", "client-a:u@example.com", http.StatusUnauthorized},
+		{"b.example.com", "client-b:u@example.org", http.StatusUnauthorized},
+		{"c.example.com", "client-a:u@example.com", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := ssoLogin(tt.host, tt.idToken)
+		if w.Code != tt.want {
+			t.Errorf("%s with %s: got status %d, want %d", tt.host, tt.idToken, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && !strings.Contains(w.Header().Get("Set-Cookie"), gatewayTokenCookie) {
+			t.Errorf("%s with %s: cookie not set", tt.host, tt.idToken)
+		}
+	}
+}
+
+func TestSSOPagePerHost(t *testing.T) {
+	defer setTargets(map[string]ssoTarget{
+		"a.example.com": {ClientID: "client-a"},
+		"b.example.com": {ClientID: "client-b"},
+	})()
+	for host, clientID := range map[string]string{"a.example.com": "client-a", "b.example.com": "client-b"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		handleSSOLogin(host, jwt.NewKeySet([]byte("secret")), w, r)
+		if !strings.Contains(w.Body.String(), `content="`+clientID+`"`) {
+			t.Errorf("%s: login page does not carry its client ID %s", host, clientID)
+		}
+	}
+}
+
+func TestSSOLoginRedirect(t *testing.T) {
+	defer fakeTokenInfo(t)()
+	defer setTargets(map[string]ssoTarget{
+		"a.example.com": {ClientID: "client-a"},
+		"b.example.com": {ClientID: "client-b"},
+	})()
+	original := ssoLockout
+	ssoLockout = &lockout{}
+	defer func() { ssoLockout = original }()
+
+	for _, redirect := range []string{"/dashboard?tab=1", "https://a.example.com/page", "https://b.example.com/"} {
+		w := ssoLoginForm("a.example.com", url.Values{
+			"id_token": {"client-a:u@example.com"},
+			"redirect": {redirect},
+		})
+		if w.Code != http.StatusOK || w.Header().Get(ssoRedirectHeader) != redirect {
+			t.Errorf("%s: unexpected response. status: %d, redirect: %q", redirect, w.Code, w.Header().Get(ssoRedirectHeader))
+		}
+		if !strings.Contains(w.Header().Get("Set-Cookie"), gatewayTokenCookie) {
+			t.Errorf("%s: cookie not set", redirect)
+		}
+	}
+
+	for _, redirect := range []string{
+		"https://evil.example.org/",
+		"//evil.example.org/",
+		"/\\evil.example.org/",
+		"javascript:alert(1)",
+		"https://u@evil.example.org@a.example.com/",
+		"dashboard",
+	} {
+		w := ssoLoginForm("a.example.com", url.Values{
+			"id_token": {"client-a:u@example.com"},
+			"redirect": {redirect},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: external redirect should be rejected, status: %d", redirect, w.Code)
+		}
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Errorf("%s: cookie should not be set", redirect)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+
+	"cirello.io/svc/pkg/jwt"
+)
+
+// clientCA holds the certificate authority that signs the client
+// certificates, so it can be reloaded without restarting the server. New TLS
+// handshakes use the fresh material, while the existing connections carry on.
+// The material also signs the tokens of the gateway: on reload, the tokens
+// signed with the previous one remain valid until the next reload.
+type clientCA struct {
+	file string
+	keys *jwt.KeySet
+
+	mu    sync.RWMutex
+	pem   []byte
+	certs *x509.CertPool
+}
+
+func loadClientCA(file string) (*clientCA, error) {
+	ca := &clientCA{file: file}
+	if err := ca.reload(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func (ca *clientCA) reload() error {
+	pem, err := ioutil.ReadFile(ca.file)
+	if err != nil {
+		return err
+	}
+	certs, err := x509.SystemCertPool()
+	if err != nil {
+		return err
+	}
+	if ok := certs.AppendCertsFromPEM(pem); !ok {
+		return errors.New("no certificate found in " + ca.file)
+	}
+	ca.mu.Lock()
+	ca.pem, ca.certs = pem, certs
+	ca.mu.Unlock()
+	if ca.keys == nil {
+		ca.keys = jwt.NewKeySet(pem)
+	} else {
+		ca.keys.Rotate(pem)
+	}
+	return nil
+}
+
+// PEM returns the current certificate authority material.
+func (ca *clientCA) PEM() []byte {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.pem
+}
+
+func (ca *clientCA) pool() *x509.CertPool {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.certs
+}
+
+// configForClient derives from base the TLS configuration of each new
+// handshake, with the current pool of client certificate authorities.
+func (ca *clientCA) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = ca.pool()
+		return cfg, nil
+	}
+}
+
+// reloadOnSignal reloads the certificate authority every time one of the
+// given signals is received. On failure, the previous material is kept.
+func (ca *clientCA) reloadOnSignal(sig ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig...)
+	for range c {
+		if err := ca.reload(); err != nil {
+			log.Println("cannot reload", ca.file+":", err)
+			continue
+		}
+		log.Println("reloaded", ca.file)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca testCA) issue(t *testing.T, email string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestClientCAReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+
+	oldCA, newCA := newTestCA(t, "old"), newTestCA(t, "new")
+	if err := ioutil.WriteFile(caFile, oldCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := loadClientCA(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven}
+	getConfig := ca.configForClient(base)
+	verifies := func(cert *x509.Certificate) bool {
+		cfg, err := getConfig(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:     cfg.ClientCAs,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		return err == nil
+	}
+
+	newClient := newCA.issue(t, "u@example.com")
+	if verifies(newClient) {
+		t.Fatal("certificate signed by an unknown CA should not verify")
+	}
+	if err := ioutil.WriteFile(caFile, newCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !verifies(newClient) {
+		t.Error("certificate signed by the new CA should verify after the reload")
+	}
+	if string(ca.PEM()) != string(newCA.pem) {
+		t.Error("the CA material was not updated")
+	}
+
+	if err := ioutil.WriteFile(caFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.reload(); err == nil {
+		t.Error("reloading an invalid CA should fail")
+	}
+	if !verifies(newClient) {
+		t.Error("a failed reload should keep the previous CA")
+	}
+}
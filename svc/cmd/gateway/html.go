@@ -31,18 +31,21 @@ function onSignIn(googleUser) {
 	xhr.open('POST', '/ssoLogin');
 	xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
 	xhr.onload = function() {
+		var redirect = xhr.getResponseHeader('` + ssoRedirectHeader + `')
 		document.getElementById("msg").innerHTML="redirecting in 5s"
 		document.getElementById("signout").style=""
 		document.getElementById("signin").style="display: none"
 		setTimeout(function(){
-			if (!signedout){
+			if (!signedout && redirect){
+				window.location = redirect
+			} else if (!signedout){
 				location.reload()
 			} else {
 				setCookie('` + gatewayTokenCookie + `','',-1)
 			}
 		}, 5000)
 	};
-	xhr.send('id_token=' + id_token);
+	xhr.send('id_token=' + id_token + '&redirect=' + encodeURIComponent(location.href));
 }
 function setCookie(cname, cvalue, exdays) {
 	var d = new Date();
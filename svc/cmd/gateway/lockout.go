@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ssoLockout holds back the sources of repeated invalid logins.
+var ssoLockout = &lockout{
+	threshold: envInt("GATEWAY_LOCKOUT_THRESHOLD", 5),
+	cooldown:  envDuration("GATEWAY_LOCKOUT_COOLDOWN", 15*time.Minute),
+}
+
+// maxLockoutSources is how many sources a lockout tracks by default.
+const maxLockoutSources = 10000
+
+// lockout tracks the invalid login attempts per source (IP address or
+// email). Once a source reaches threshold failures, it is locked out until
+// cooldown elapses since its last failure. At most maxSources are tracked:
+// once full, the expired sources are evicted and, failing that, the one that
+// failed the longest ago.
+type lockout struct {
+	threshold  int
+	cooldown   time.Duration
+	maxSources int              // defaults to maxLockoutSources
+	now        func() time.Time // defaults to time.Now
+
+	mu       sync.Mutex
+	failures map[string]*failedLogins
+}
+
+type failedLogins struct {
+	count int
+	last  time.Time
+}
+
+func (l *lockout) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// locked tells whether any of the sources is locked out.
+func (l *lockout) locked(sources ...string) bool {
+	if l.threshold <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock()
+	for _, src := range sources {
+		f, ok := l.failures[src]
+		if !ok {
+			continue
+		}
+		if now.Sub(f.last) >= l.cooldown {
+			delete(l.failures, src)
+			continue
+		}
+		if f.count >= l.threshold {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lockout) fail(sources ...string) {
+	if l.threshold <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures == nil {
+		l.failures = make(map[string]*failedLogins)
+	}
+	now := l.clock()
+	for _, src := range sources {
+		if src == "" {
+			continue
+		}
+		f, ok := l.failures[src]
+		if !ok || now.Sub(f.last) >= l.cooldown {
+			if !ok {
+				l.makeRoom(now)
+			}
+			f = &failedLogins{}
+			l.failures[src] = f
+		}
+		f.count++
+		f.last = now
+	}
+}
+
+// makeRoom evicts sources until there is room for a new one.
+func (l *lockout) makeRoom(now time.Time) {
+	max := l.maxSources
+	if max <= 0 {
+		max = maxLockoutSources
+	}
+	if len(l.failures) < max {
+		return
+	}
+	for src, f := range l.failures {
+		if now.Sub(f.last) >= l.cooldown {
+			delete(l.failures, src)
+		}
+	}
+	for len(l.failures) >= max {
+		var oldest string
+		for src, f := range l.failures {
+			if oldest == "" || f.last.Before(l.failures[oldest].last) {
+				oldest = src
+			}
+		}
+		delete(l.failures, oldest)
+	}
+}
+
+func (l *lockout) reset(sources ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, src := range sources {
+		delete(l.failures, src)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSSOLockout(t *testing.T) {
+	defer fakeTokenInfo(t)()
+	defer setTargets(map[string]ssoTarget{
+		"a.example.com": {ClientID: "client-a"},
+	})()
+	now := time.Now()
+	original := ssoLockout
+	ssoLockout = &lockout{threshold: 3, cooldown: time.Minute, now: func() time.Time { return now }}
+	defer func() { ssoLockout = original }()
+
+	for i := 0; i < 3; i++ {
+		if w := ssoLogin("a.example.com", "client-b:u@example.com"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: unexpected status %d", i, w.Code)
+		}
+	}
+	if w := ssoLogin("a.example.com", "client-a:u@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Error("valid login during the lockout should be rejected, status:", w.Code)
+	}
+
+	now = now.Add(time.Minute)
+	if w := ssoLogin("a.example.com", "client-a:u@example.com"); w.Code != http.StatusOK {
+		t.Error("valid login after the cooldown should succeed, status:", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		ssoLogin("a.example.com", "client-b:u@example.com")
+	}
+	if w := ssoLogin("a.example.com", "client-a:u@example.com"); w.Code != http.StatusOK {
+		t.Error("successful login should reset the failures, status:", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		ssoLogin("a.example.com", "client-b:u@example.com")
+	}
+	if w := ssoLogin("a.example.com", "client-b:u@example.com"); w.Code != http.StatusUnauthorized {
+		t.Error("failures before a successful login should not count, status:", w.Code)
+	}
+}
+
+func TestLockoutPerSource(t *testing.T) {
+	l := &lockout{threshold: 2, cooldown: time.Minute}
+	l.fail("192.0.2.1", "u@example.com")
+	l.fail("192.0.2.2", "u@example.com")
+	if l.locked("192.0.2.1") || l.locked("192.0.2.2") {
+		t.Error("IP addresses should not be locked out after a single failure each")
+	}
+	if !l.locked("192.0.2.3", "u@example.com") {
+		t.Error("email should be locked out after failing from different IP addresses")
+	}
+}
+
+func TestLockoutEviction(t *testing.T) {
+	now := time.Now()
+	l := &lockout{threshold: 1, cooldown: time.Minute, maxSources: 2, now: func() time.Time { return now }}
+	l.fail("192.0.2.1")
+	now = now.Add(time.Minute)
+	l.fail("192.0.2.2")
+	now = now.Add(time.Second)
+	l.fail("192.0.2.3")
+	if len(l.failures) != 2 || !l.locked("192.0.2.2") || !l.locked("192.0.2.3") {
+		t.Errorf("expired sources should be evicted first, tracked: %v", l.failures)
+	}
+	now = now.Add(time.Second)
+	l.fail("192.0.2.4")
+	if len(l.failures) != 2 || l.locked("192.0.2.2") || !l.locked("192.0.2.3") || !l.locked("192.0.2.4") {
+		t.Errorf("the source that failed the longest ago should be evicted once full, tracked: %v", l.failures)
+	}
+}
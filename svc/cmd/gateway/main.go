@@ -8,6 +8,11 @@ import (
 
 const gatewayTokenCookie = "gateway-jwt"
 
+// ssoRedirectHeader carries the validated redirect of a SSO login, which the
+// login page follows itself: a redirect response would be followed by its
+// XMLHttpRequest instead.
+const ssoRedirectHeader = "X-Gateway-Redirect"
+
 var (
 	publicBindIP   = os.Getenv("GATEWAY_PUBLIC_BIND_IP")
 	servicesBindIP = os.Getenv("GATEWAY_SERVICES_BIND_IP")
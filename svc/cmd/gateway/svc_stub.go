@@ -4,18 +4,16 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"syscall"
 
-	"cirello.io/svc/pkg/jwt"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-var acceptableTargets = map[string]struct{}{}
+var acceptableTargets = map[string]ssoTarget{}
 
 func services() {
 	log.Println("bootstrapping services")
@@ -42,37 +40,30 @@ func services() {
 		log.Fatalln("unable to parse client certificate signatures")
 	}
 
-	certBytes, err := ioutil.ReadFile("ca.pem")
+	ca, err := loadClientCA("ca.pem")
 	if err != nil {
-		log.Fatalln("Unable to read ca.pem", err)
-	}
-
-	clientCAs, err := x509.SystemCertPool()
-	if err != nil {
-		log.Fatalln("unable to load system CA pool", err)
-	}
-	if ok := clientCAs.AppendCertsFromPEM(certBytes); !ok {
-		log.Fatalln("Unable to add certificate to certificate pool")
+		log.Fatalln("unable to load client CA", err)
 	}
+	go ca.reloadOnSignal(syscall.SIGHUP)
 
 	s := &http.Server{
 		Addr: servicesBindIP + ":https",
 		TLSConfig: &tls.Config{
 			GetCertificate: m.GetCertificate,
 			ClientAuth:     tls.VerifyClientCertIfGiven,
-			ClientCAs:      clientCAs,
+			ClientCAs:      ca.pool(),
 		},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if cert := detectedClientCertificate(r, allowedCertificates); cert != nil {
-				token, err := jwt.CreateFromCert(r.Host, certBytes, cert, false)
+				token, err := ca.keys.CreateFromCert(r.Host, cert, false)
 				if err == nil {
 					r.Header.Set("Authorization", "bearer "+token)
 				}
 			} else if cookie, err := r.Cookie(gatewayTokenCookie); err != nil || cookie.Value == "" {
-				handleSSOLogin(r.Host, certBytes, w, r)
+				handleSSOLogin(r.Host, ca.keys, w, r)
 				return
-			} else if token, _, err := jwt.Parse(cookie.Value, certBytes); err != nil || !token.Valid {
-				handleSSOLogin(r.Host, certBytes, w, r)
+			} else if _, err := ca.keys.Verify(r.Host, cookie.Value); err != nil {
+				handleSSOLogin(r.Host, ca.keys, w, r)
 				return
 			} else {
 				r.Header.Set("Authorization", "bearer "+cookie.Value)
@@ -82,6 +73,7 @@ func services() {
 			http.NotFound(w, r)
 		}),
 	}
+	s.TLSConfig.GetConfigForClient = ca.configForClient(s.TLSConfig)
 	log.Println("starting svc:443")
 	log.Println("svc:443", s.ListenAndServeTLS("", ""))
 }
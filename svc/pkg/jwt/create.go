@@ -18,50 +18,55 @@ type ServiceClaims struct {
 	// Trust defines the trust level so to give the application some context
 	// on how it should handle low-trust logins.
 	Trust string
+	// Extra carries additional claims, like groups or display name, for
+	// the downstream services.
+	Extra map[string]interface{} `json:",omitempty"`
 
 	jwt.StandardClaims
 }
 
 // CreateFromCert a JWT whose content indicate a high-trust login.
 func CreateFromCert(svcName string, caPEM []byte, cert *x509.Certificate, trustedHost bool) (string, error) {
+	return NewKeySet(caPEM).CreateFromCert(svcName, cert, trustedHost)
+}
+
+// CreateFromEmail a JWT whose content indicate a low-trust login.
+func CreateFromEmail(svcName string, caPEM []byte, email string, expiration time.Duration) (string, error) {
+	return CreateFromEmailWithClaims(svcName, caPEM, email, nil, expiration)
+}
+
+// CreateFromEmailWithClaims a JWT whose content indicate a low-trust login,
+// carrying the given additional claims.
+func CreateFromEmailWithClaims(svcName string, caPEM []byte, email string, extra map[string]interface{}, expiration time.Duration) (string, error) {
+	return NewKeySet(caPEM).CreateFromEmailWithClaims(svcName, email, extra, expiration)
+}
+
+func certClaims(svcName string, cert *x509.Certificate, trustedHost bool) (*ServiceClaims, error) {
 	if len(cert.EmailAddresses) == 0 {
-		return "", errors.E("certificate missing email")
+		return nil, errors.E("certificate missing email")
 	} else if len(cert.EmailAddresses) > 1 {
-		return "", errors.E("multiple emails in the same certificate - cannot choose one")
+		return nil, errors.E("multiple emails in the same certificate - cannot choose one")
 	}
 
 	trust := "medium"
 	if trustedHost {
 		trust = "high"
 	}
-	email := cert.EmailAddresses[0]
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS512,
-		&ServiceClaims{
-			Email:  email,
-			Target: svcName,
-			Trust:  trust,
-		},
-	)
-
-	tokenString, err := token.SignedString(caPEM)
-	return tokenString, errors.E(err, "cannot sign JWT")
+	return &ServiceClaims{
+		Email:  cert.EmailAddresses[0],
+		Target: svcName,
+		Trust:  trust,
+	}, nil
 }
 
-// CreateFromEmail a JWT whose content indicate a low-trust login.
-func CreateFromEmail(svcName string, caPEM []byte, email string, expiration time.Duration) (string, error) {
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS512,
-		&ServiceClaims{
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(expiration).Unix(),
-			},
-			Email:  email,
-			Target: svcName,
-			Trust:  "low",
+func emailClaims(svcName string, email string, extra map[string]interface{}, expiration time.Duration) *ServiceClaims {
+	return &ServiceClaims{
+		Extra: extra,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(expiration).Unix(),
 		},
-	)
-
-	tokenString, err := token.SignedString(caPEM)
-	return tokenString, errors.E(err, "cannot sign JWT")
+		Email:  email,
+		Target: svcName,
+		Trust:  "low",
+	}
 }
@@ -0,0 +1,148 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"cirello.io/errors"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// KeySet holds the keys that sign and verify the tokens. New tokens are signed
+// with the current key, while the tokens signed with the previous keys remain
+// valid during a rotation window, until these keys are retired.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys [][]byte // current key first
+}
+
+// NewKeySet creates a key set that signs with current and still verifies the
+// tokens signed with any of the previous keys.
+func NewKeySet(current []byte, previous ...[]byte) *KeySet {
+	return &KeySet{keys: append([][]byte{current}, previous...)}
+}
+
+// Rotate makes key the current signing key. The former current key is kept
+// for verification, and the older ones are retired.
+func (ks *KeySet) Rotate(key []byte) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if keyID(ks.keys[0]) == keyID(key) {
+		return
+	}
+	ks.keys = [][]byte{key, ks.keys[0]}
+}
+
+// Retire drops all but the current key, ending the rotation window.
+func (ks *KeySet) Retire() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = ks.keys[:1]
+}
+
+func (ks *KeySet) current() []byte {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[0]
+}
+
+func (ks *KeySet) active() [][]byte {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return append([][]byte(nil), ks.keys...)
+}
+
+// keyID identifies a key in the "kid" header of the tokens, without revealing
+// it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (ks *KeySet) sign(claims *ServiceClaims) (string, error) {
+	key := ks.current()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	token.Header["kid"] = keyID(key)
+	tokenString, err := token.SignedString(key)
+	return tokenString, errors.E(err, "cannot sign JWT")
+}
+
+// CreateFromCert a JWT, signed with the current key, whose content indicate a
+// high-trust login.
+func (ks *KeySet) CreateFromCert(svcName string, cert *x509.Certificate, trustedHost bool) (string, error) {
+	claims, err := certClaims(svcName, cert, trustedHost)
+	if err != nil {
+		return "", err
+	}
+	return ks.sign(claims)
+}
+
+// CreateFromEmail a JWT, signed with the current key, whose content indicate
+// a low-trust login.
+func (ks *KeySet) CreateFromEmail(svcName string, email string, expiration time.Duration) (string, error) {
+	return ks.CreateFromEmailWithClaims(svcName, email, nil, expiration)
+}
+
+// CreateFromEmailWithClaims a JWT, signed with the current key, whose content
+// indicate a low-trust login, carrying the given additional claims.
+func (ks *KeySet) CreateFromEmailWithClaims(svcName string, email string, extra map[string]interface{}, expiration time.Duration) (string, error) {
+	return ks.sign(emailClaims(svcName, email, extra, expiration))
+}
+
+// Parse decodes the JWT from the given string, verifying it against the
+// active keys. Tokens naming their key are verified only against it. It will
+// return only a valid token, and an error otherwise.
+func (ks *KeySet) Parse(t string) (*jwt.Token, ServiceClaims, error) {
+	var claims ServiceClaims
+	token, err := ks.parse(t, &claims)
+	if err != nil {
+		return nil, ServiceClaims{},
+			errors.E(errors.Invalid, err, "cannot parse token")
+	}
+	if !token.Valid {
+		return nil, ServiceClaims{},
+			errors.E(errors.Invalid, "not is not valid")
+	}
+	return token, claims, nil
+}
+
+var errUnknownKey = errors.E(errors.Invalid, "signed with an unknown key")
+
+func (ks *KeySet) parse(t string, claims *ServiceClaims) (*jwt.Token, error) {
+	keys := ks.active()
+	if unverified, _, err := new(jwt.Parser).ParseUnverified(t, &ServiceClaims{}); err == nil {
+		if kid, ok := unverified.Header["kid"].(string); ok {
+			keys = keysByID(keys, kid)
+		}
+	}
+	var (
+		token *jwt.Token
+		err   = errUnknownKey
+	)
+	for _, key := range keys {
+		*claims = ServiceClaims{}
+		token, err = jwt.ParseWithClaims(t, claims,
+			func(token *jwt.Token) (interface{}, error) {
+				if token.Method != jwt.SigningMethodHS512 {
+					return nil, errors.E(errors.Invalid, "unexpected signing method")
+				}
+				return key, nil
+			})
+		if err == nil {
+			return token, nil
+		}
+	}
+	return nil, err
+}
+
+func keysByID(keys [][]byte, kid string) [][]byte {
+	for _, key := range keys {
+		if keyID(key) == kid {
+			return [][]byte{key}
+		}
+	}
+	return nil
+}
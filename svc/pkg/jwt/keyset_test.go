@@ -0,0 +1,62 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, newKey, newerKey := []byte("old"), []byte("new"), []byte("newer")
+	ks := NewKeySet(oldKey)
+	oldToken, err := ks.CreateFromEmail("svc", "u@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks.Rotate(newKey)
+	newToken, err := ks.CreateFromEmail("svc", "u@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, claims, err := ks.Parse(oldToken); err != nil || claims.Email != "u@example.com" {
+		t.Error("token signed with the previous key should remain valid:", err)
+	}
+	if _, _, err := ks.Parse(newToken); err != nil {
+		t.Error("token signed with the current key should be valid:", err)
+	}
+	if _, _, err := Parse(newToken, newKey); err != nil {
+		t.Error("new tokens should be signed with the current key:", err)
+	}
+	if _, _, err := Parse(newToken, oldKey); err == nil {
+		t.Error("new tokens should not be signed with the previous key")
+	}
+
+	ks.Rotate(newerKey)
+	if _, _, err := ks.Parse(oldToken); err == nil {
+		t.Error("token signed with a retired key should be invalid")
+	}
+	if _, _, err := ks.Parse(newToken); err != nil {
+		t.Error("token signed with the previous key should remain valid:", err)
+	}
+	ks.Retire()
+	if _, _, err := ks.Parse(newToken); err == nil {
+		t.Error("token signed with a retired key should be invalid")
+	}
+}
+
+func TestKeySetUnnamedKeys(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, emailClaims("svc", "u@example.com", nil, time.Hour))
+	unnamedToken, err := token.SignedString([]byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeySet([]byte("new"), []byte("old"))
+	if _, _, err := ks.Parse(unnamedToken); err != nil {
+		t.Error("tokens without key ID should be verified against all active keys:", err)
+	}
+	if _, _, err := NewKeySet([]byte("other")).Parse(unnamedToken); err == nil {
+		t.Error("tokens signed with an unknown key should be invalid")
+	}
+}
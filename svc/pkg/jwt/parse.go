@@ -11,20 +11,7 @@ import (
 // Parse decodes the JWT from the given string. It will return only a valid
 // token, and an error otherwise.
 func Parse(t string, caPEM []byte) (*jwt.Token, ServiceClaims, error) {
-	var claims ServiceClaims
-	token, err := jwt.ParseWithClaims(t, &claims,
-		func(token *jwt.Token) (interface{}, error) {
-			return caPEM, nil
-		})
-	if err != nil {
-		return nil, ServiceClaims{},
-			errors.E(errors.Invalid, err, "cannot parse token")
-	}
-	if !token.Valid {
-		return nil, ServiceClaims{},
-			errors.E(errors.Invalid, "not is not valid")
-	}
-	return token, claims, nil
+	return NewKeySet(caPEM).Parse(t)
 }
 
 // Claims from a given token. It will return not OK if a ServiceClaim is not
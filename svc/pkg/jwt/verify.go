@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"time"
+
+	"cirello.io/errors"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Errors returned by Verify.
+var (
+	// ErrInvalid is returned for malformed tokens, or signed with an
+	// unknown key or method.
+	ErrInvalid = errors.E(errors.Invalid, "token is invalid")
+	// ErrTampered is returned when the signature does not match the
+	// content of the token.
+	ErrTampered = errors.E(errors.Invalid, "token signature is invalid")
+	// ErrExpired is returned for tokens past their expiration.
+	ErrExpired = errors.E(errors.Invalid, "token is expired")
+	// ErrAudience is returned for tokens created for another service.
+	ErrAudience = errors.E(errors.Invalid, "token was created for another service")
+)
+
+// VerifiedClaims are the claims of a token that passed Verify. It is named so
+// not to clash with the Claims function.
+type VerifiedClaims struct {
+	// Email is the actor who logged in.
+	Email string
+	// Issuer of the token, if any.
+	Issuer string
+	// Target is the service for which the token was created.
+	Target string
+	// Trust is the trust level of the login.
+	Trust string
+	// ExpiresAt is when the token expires. Zero for tokens that do not
+	// expire.
+	ExpiresAt time.Time
+	// Extra are the additional claims of the token, if any.
+	Extra map[string]interface{}
+}
+
+// Verify decodes the JWT from the given string and fully validates it: its
+// signature, its expiration and its audience, which must be svcName. The
+// errors are one of ErrInvalid, ErrTampered, ErrExpired or ErrAudience.
+func Verify(svcName string, caPEM []byte, raw string) (VerifiedClaims, error) {
+	return NewKeySet(caPEM).Verify(svcName, raw)
+}
+
+// Verify is like the package function Verify, checking the signature against
+// the active keys.
+func (ks *KeySet) Verify(svcName string, raw string) (VerifiedClaims, error) {
+	var claims ServiceClaims
+	if _, err := ks.parse(raw, &claims); err != nil {
+		verr, ok := err.(*jwt.ValidationError)
+		switch {
+		case !ok:
+			return VerifiedClaims{}, ErrInvalid
+		case verr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return VerifiedClaims{}, ErrTampered
+		case verr.Errors&jwt.ValidationErrorExpired != 0:
+			return VerifiedClaims{}, ErrExpired
+		default:
+			return VerifiedClaims{}, ErrInvalid
+		}
+	}
+	if claims.Target != svcName {
+		return VerifiedClaims{}, ErrAudience
+	}
+	vc := VerifiedClaims{
+		Email:  claims.Email,
+		Issuer: claims.Issuer,
+		Target: claims.Target,
+		Trust:  claims.Trust,
+		Extra:  claims.Extra,
+	}
+	if claims.ExpiresAt != 0 {
+		vc.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	return vc, nil
+}
@@ -0,0 +1,103 @@
+package jwt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	raw, err := CreateFromEmail("svc.example.com", key, "u@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := Verify("svc.example.com", key, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "u@example.com" || claims.Target != "svc.example.com" || claims.Trust != "low" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if d := time.Until(claims.ExpiresAt); d <= 0 || d > time.Hour {
+		t.Error("unexpected expiration:", claims.ExpiresAt)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	key := []byte("secret")
+	valid, err := CreateFromEmail("svc.example.com", key, "u@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := CreateFromEmail("svc.example.com", key, "u@example.com", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := CreateFromEmail("svc.example.com", []byte("other"), "u@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+	forged, err := CreateFromEmail("svc.example.com", key, "admin@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+	hs256 := jwt.NewWithClaims(jwt.SigningMethodHS256, emailClaims("svc.example.com", "u@example.com", nil, time.Hour))
+	otherMethod, err := hs256.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		svcName string
+		raw     string
+		want    error
+	}{
+		{"malformed", "svc.example.com", "not-a-token", ErrInvalid},
+		{"unknown key", "svc.example.com", otherKey, ErrInvalid},
+		{"other method", "svc.example.com", otherMethod, ErrInvalid},
+		{"tampered", "svc.example.com", tampered, ErrTampered},
+		{"expired", "svc.example.com", expired, ErrExpired},
+		{"audience", "other.example.com", valid, ErrAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(tt.svcName, key, tt.raw); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyExtraClaims(t *testing.T) {
+	key := []byte("secret")
+	extra := map[string]interface{}{
+		"name":   "User",
+		"groups": []interface{}{"admins", "ops"},
+	}
+	raw, err := CreateFromEmailWithClaims("svc.example.com", key, "u@example.com", extra, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := Verify("svc.example.com", key, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(claims.Extra, extra) {
+		t.Errorf("unexpected extra claims: %#v", claims.Extra)
+	}
+
+	raw, err = CreateFromEmail("svc.example.com", key, "u@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := Verify("svc.example.com", key, raw); err != nil || claims.Extra != nil {
+		t.Errorf("unexpected extra claims: %#v (%v)", claims.Extra, err)
+	}
+}